@@ -0,0 +1,113 @@
+package redisdb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ackBuffer accumulates the IDs to ack per stream
+type ackBuffer struct {
+	mu  sync.Mutex
+	ids map[string][]string
+	n   int
+}
+
+// add buffers the ID, it reports whether the batch is full
+func (b *ackBuffer) add(stream, id string, size int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ids == nil {
+		b.ids = make(map[string][]string)
+	}
+	b.ids[stream] = append(b.ids[stream], id)
+	b.n++
+	return b.n >= size
+}
+
+// take empties the buffer and returns its content
+func (b *ackBuffer) take() map[string][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ids := b.ids
+	b.ids = nil
+	b.n = 0
+	return ids
+}
+
+// batchAcks reports whether the acks are buffered
+func (w *Worker) batchAcks() bool {
+	return w.opts.ackBatchSize > 1
+}
+
+// flushAcks acks the buffered IDs with a single XACK per stream. The
+// IDs of a failed XACK stay pending and can be reclaimed.
+func (w *Worker) flushAcks(ctx context.Context) error {
+	var firstErr error
+	for stream, ids := range w.acks.take() {
+		if err := w.xack(ctx, stream, ids...); err != nil {
+			w.handleError(err, ErrorContext{Stage: ErrorStageAck, Stream: stream})
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		w.countAcks(stream, len(ids))
+	}
+
+	return firstErr
+}
+
+// periodicAckFlush flushes the buffered acks on every flush interval
+func (w *Worker) periodicAckFlush() {
+	ticker := time.NewTicker(w.opts.ackFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.flushAcks(w.ctx); err != nil {
+			w.opts.logger.Errorf("can't flush acks: %v", err)
+		}
+	}
+}
+
+// xack acks the IDs, retried with WithAckRetry. The IDs which failed
+// every attempt on a transient error are deferred to the next read.
+func (w *Worker) xack(ctx context.Context, stream string, ids ...string) error {
+	_, err := w.withRetry(ctx, w.opts.ackAttempts, w.opts.retryBase, func() error {
+		return w.rdb.XAck(ctx, stream, w.opts.group, ids...).Err()
+	})
+	if err != nil && w.opts.ackAttempts > 1 && isRetriable(err) {
+		for _, id := range ids {
+			w.deferredAcks.add(stream, id, 0)
+		}
+	}
+
+	return err
+}
+
+// retryDeferredAcks acks again the IDs which failed every attempt of
+// WithAckRetry, the ones failing again on a transient error stay deferred.
+func (w *Worker) retryDeferredAcks(ctx context.Context) {
+	for stream, ids := range w.deferredAcks.take() {
+		if err := w.rdb.XAck(ctx, stream, w.opts.group, ids...).Err(); err != nil {
+			w.opts.logger.Errorf("can't ack %d deferred messages of %s: %v", len(ids), stream, err)
+			if isRetriable(err) {
+				for _, id := range ids {
+					w.deferredAcks.add(stream, id, 0)
+				}
+			}
+			continue
+		}
+		w.countAcks(stream, len(ids))
+	}
+}
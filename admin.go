@@ -0,0 +1,409 @@
+package redisdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-queue/queue/job"
+	"github.com/redis/go-redis/v9"
+)
+
+// DeleteConsumer removes the consumers of this worker from the group of
+// every stream. It returns the number of pending messages they still
+// owned, those are orphaned and can only be claimed by another consumer.
+func (w *Worker) DeleteConsumer(ctx context.Context) (int64, error) {
+	var orphaned int64
+	for _, stream := range w.opts.streams {
+		for _, consumer := range w.consumers() {
+			n, err := w.rdb.XGroupDelConsumer(ctx, stream, w.opts.group, consumer).Result()
+			if err != nil {
+				return orphaned, err
+			}
+			orphaned += n
+		}
+	}
+
+	return orphaned, nil
+}
+
+// DeleteGroup destroys the consumer group of every stream, e.g. to
+// tear down a test
+func (w *Worker) DeleteGroup(ctx context.Context) error {
+	for _, stream := range w.opts.streams {
+		if err := w.rdb.XGroupDestroy(ctx, stream, w.opts.group).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HealthCheck names a check run by Health
+type HealthCheck string
+
+const (
+	// HealthCheckPing checks the connection to redis
+	HealthCheckPing HealthCheck = "ping"
+	// HealthCheckStream checks the stream exists
+	HealthCheckStream HealthCheck = "stream"
+	// HealthCheckGroup checks the consumer group exists on the stream
+	HealthCheckGroup HealthCheck = "group"
+)
+
+// HealthError is returned by Health, it tells which check failed
+type HealthError struct {
+	Check  HealthCheck
+	Stream string
+	Err    error
+}
+
+func (e *HealthError) Error() string {
+	if e.Stream == "" {
+		return fmt.Sprintf("health check %s failed: %v", e.Check, e.Err)
+	}
+	return fmt.Sprintf("health check %s failed for stream %s: %v", e.Check, e.Stream, e.Err)
+}
+
+func (e *HealthError) Unwrap() error {
+	return e.Err
+}
+
+// Health verifies the connection and that every stream and its consumer
+// group exist, which catches a stream or group deleted at runtime.
+// It costs one round trip per check, cheap enough for readiness probes.
+func (w *Worker) Health(ctx context.Context) error {
+	if err := w.rdb.Ping(ctx).Err(); err != nil {
+		return &HealthError{Check: HealthCheckPing, Err: err}
+	}
+
+	for _, stream := range w.opts.streams {
+		if err := w.rdb.XInfoStream(ctx, stream).Err(); err != nil {
+			if isWrongType(err) {
+				err = fmt.Errorf("%w: %w", ErrWrongType, err)
+			}
+			return &HealthError{Check: HealthCheckStream, Stream: stream, Err: err}
+		}
+		if w.opts.noGroup {
+			continue
+		}
+
+		groups, err := w.rdb.XInfoGroups(ctx, stream).Result()
+		if err != nil {
+			return &HealthError{Check: HealthCheckGroup, Stream: stream, Err: err}
+		}
+		if _, ok := findGroup(groups, w.opts.group); !ok {
+			return &HealthError{Check: HealthCheckGroup, Stream: stream, Err: ErrGroupNotFound}
+		}
+	}
+
+	return nil
+}
+
+func findGroup(groups []redis.XInfoGroup, name string) (redis.XInfoGroup, bool) {
+	for _, g := range groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return redis.XInfoGroup{}, false
+}
+
+// ConsumerName returns the resolved consumer name, the read loops of
+// WithConcurrency append their index to it.
+func (w *Worker) ConsumerName() string {
+	return w.opts.consumer
+}
+
+// GroupInfo returns the state of the consumer group on the main stream,
+// the first one of WithStreams, such as its last delivered ID and the
+// number of pending entries.
+func (w *Worker) GroupInfo(ctx context.Context) (redis.XInfoGroup, error) {
+	groups, err := w.rdb.XInfoGroups(ctx, w.opts.streamName).Result()
+	if err != nil {
+		return redis.XInfoGroup{}, err
+	}
+	group, ok := findGroup(groups, w.opts.group)
+	if !ok {
+		return redis.XInfoGroup{}, fmt.Errorf("%w: stream %s", ErrGroupNotFound, w.opts.streamName)
+	}
+
+	return group, nil
+}
+
+// ConsumerInfo returns the consumers of the group on the main stream
+// with their pending count and idle time.
+func (w *Worker) ConsumerInfo(ctx context.Context) ([]redis.XInfoConsumer, error) {
+	return w.rdb.XInfoConsumers(ctx, w.opts.streamName, w.opts.group).Result()
+}
+
+// QueueLength returns the number of entries in the streams, acked or not
+func (w *Worker) QueueLength(ctx context.Context) (int64, error) {
+	var total int64
+	for _, stream := range w.opts.streams {
+		n, err := w.rdb.XLen(ctx, stream).Result()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+
+	return total, nil
+}
+
+// Lag returns the number of entries which weren't delivered to the
+// consumer group yet, it relies on the lag reported by XINFO GROUPS
+// and returns ErrLagUnsupported before redis 7. Redis can't compute the
+// lag of a stream once entries were deleted or trimmed past the group,
+// ErrLagUnknown is returned then.
+func (w *Worker) Lag(ctx context.Context) (int64, error) {
+	major, err := w.serverMajorVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if major < 7 {
+		return 0, ErrLagUnsupported
+	}
+
+	var total int64
+	for _, stream := range w.opts.streams {
+		lag, err := w.groupLag(ctx, stream)
+		if err != nil {
+			return 0, err
+		}
+		total += lag
+	}
+
+	return total, nil
+}
+
+// groupLag returns the lag of the group on the stream. go-redis reports
+// the nil lag of redis as zero, so the raw reply is read instead.
+func (w *Worker) groupLag(ctx context.Context, stream string) (int64, error) {
+	// redis.Cmdable has no Do
+	cmd := redis.NewCmd(ctx, "xinfo", "groups", stream)
+	if _, err := w.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		return pipe.Process(ctx, cmd)
+	}); err != nil {
+		return 0, err
+	}
+
+	groups, err := cmd.Slice()
+	if err != nil {
+		return 0, err
+	}
+	for _, g := range groups {
+		fields := replyFields(g)
+		if fields["name"] != w.opts.group {
+			continue
+		}
+		lag, ok := fields["lag"].(int64)
+		if !ok {
+			return 0, fmt.Errorf("%w: stream %s", ErrLagUnknown, stream)
+		}
+		return lag, nil
+	}
+
+	return 0, fmt.Errorf("%w: stream %s", ErrGroupNotFound, stream)
+}
+
+// replyFields returns the fields of a map reply, sent as a flat array of
+// keys and values with RESP2
+func replyFields(reply interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	switch v := reply.(type) {
+	case map[interface{}]interface{}:
+		for k, value := range v {
+			if key, ok := k.(string); ok {
+				fields[key] = value
+			}
+		}
+	case []interface{}:
+		for i := 0; i+1 < len(v); i += 2 {
+			if key, ok := v[i].(string); ok {
+				fields[key] = v[i+1]
+			}
+		}
+	}
+	return fields
+}
+
+// serverMajorVersion returns the major version of the redis server
+func (w *Worker) serverMajorVersion(ctx context.Context) (int, error) {
+	info, err := w.rdb.Info(ctx, "server").Result()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(info, "\n") {
+		version, found := strings.CutPrefix(strings.TrimSpace(line), "redis_version:")
+		if !found {
+			continue
+		}
+		major, _, _ := strings.Cut(version, ".")
+		return strconv.Atoi(major)
+	}
+
+	return 0, errors.New("redis_version not found in server info")
+}
+
+// purgePageSize is the max number of pending entries handled per round trip
+// of PurgePending
+const purgePageSize = 100
+
+// PendingSummary returns the pending entries list summary of the group on
+// the main stream: the number of entries, the smallest and greatest IDs
+// and the pending count per consumer.
+func (w *Worker) PendingSummary(ctx context.Context) (redis.XPending, error) {
+	pending, err := w.rdb.XPending(ctx, w.opts.streamName, w.opts.group).Result()
+	if err != nil {
+		return redis.XPending{}, err
+	}
+
+	return *pending, nil
+}
+
+// PurgePending removes from the pending entries list of every stream the
+// entries idle for at least minIdle, whichever consumer owns them. They
+// are moved to the dead-letter stream when WithMaxRetries is set and acked
+// otherwise. The list is walked by pages so a huge one doesn't block redis.
+// It returns the number of purged entries.
+func (w *Worker) PurgePending(ctx context.Context, minIdle time.Duration) (int, error) {
+	var purged int
+	for _, stream := range w.opts.streams {
+		n, err := w.purgeStream(ctx, stream, minIdle)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
+
+func (w *Worker) purgeStream(ctx context.Context, stream string, minIdle time.Duration) (int, error) {
+	var purged int
+	start := "-"
+	for {
+		pending, err := w.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  w.opts.group,
+			Idle:   minIdle,
+			Start:  start,
+			End:    "+",
+			Count:  purgePageSize,
+		}).Result()
+		if err != nil {
+			return purged, err
+		}
+		if len(pending) == 0 {
+			return purged, nil
+		}
+
+		n, err := w.purgeEntries(ctx, stream, minIdle, pending)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+		if len(pending) < purgePageSize {
+			return purged, nil
+		}
+
+		// skip the entries which couldn't be purged
+		start = "(" + pending[len(pending)-1].ID
+	}
+}
+
+func (w *Worker) purgeEntries(
+	ctx context.Context, stream string, minIdle time.Duration, pending []redis.XPendingExt,
+) (int, error) {
+	ids := make([]string, 0, len(pending))
+	failures := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		ids = append(ids, p.ID)
+		failures[p.ID] = p.RetryCount
+	}
+
+	if w.opts.maxRetries <= 0 {
+		n, err := w.rdb.XAck(ctx, stream, w.opts.group, ids...).Result()
+		return int(n), err
+	}
+
+	// claim the entries to fetch their content, the idle check skips the
+	// ones delivered again since they were listed
+	consumer := w.claimConsumer()
+	messages, err := w.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    w.opts.group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int
+	for _, m := range messages {
+		message := streamMessage{m, stream, failures[m.ID], consumer}
+		if err := w.deadLetter(ctx, message, failures[m.ID], nil); err != nil {
+			w.messageLogger(message).Error("can't dead-letter message", "error", err)
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// Fetch reads the messages with the given IDs from the streams and decodes
+// them with the codec, e.g. to examine or replay known messages. It uses
+// XRANGE so the consumer group and the pending entries list are left
+// untouched. The IDs which no longer exist, e.g. once trimmed, are skipped
+// and listed by an ErrMessageNotFound error returned with the others.
+func (w *Worker) Fetch(ctx context.Context, ids ...string) ([]job.Message, error) {
+	found := make(map[string]streamMessage, len(ids))
+	for _, stream := range w.opts.streams {
+		cmds := make(map[string]*redis.XMessageSliceCmd, len(ids))
+		_, err := w.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, id := range ids {
+				if _, ok := found[id]; !ok {
+					cmds[id] = pipe.XRangeN(ctx, stream, id, id, 1)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for id, cmd := range cmds {
+			if messages := cmd.Val(); len(messages) > 0 {
+				found[id] = streamMessage{messages[0], stream, 0, ""}
+			}
+		}
+	}
+
+	messages := make([]job.Message, 0, len(found))
+	var missing []string
+	for _, id := range ids {
+		message, ok := found[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		data, err := w.decode(message)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *data)
+	}
+	if len(missing) > 0 {
+		return messages, fmt.Errorf("%w: %s", ErrMessageNotFound, strings.Join(missing, ", "))
+	}
+
+	return messages, nil
+}
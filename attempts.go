@@ -0,0 +1,76 @@
+package redisdb
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/golang-queue/queue/core"
+)
+
+// attemptsField counts how many times a message was requeued by Requeue
+const attemptsField = "attempts"
+
+// attemptsKey is the context key of the attempt count of a message
+type attemptsKey struct{}
+
+// attempts returns the attempt count stored in the message
+func attempts(message streamMessage) int {
+	switch v := message.Values[attemptsField].(type) {
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	case int:
+		return v
+	}
+	return 0
+}
+
+// AttemptsFromContext returns how many times the task being processed was
+// requeued with Requeue, zero for its first attempt. The run func can
+// compare it to its own limit to decide whether to requeue it again.
+func AttemptsFromContext(ctx context.Context) int {
+	n, _ := ctx.Value(attemptsKey{}).(int)
+	return n
+}
+
+// Requeue adds the task being processed back to its stream with its
+// attempt count incremented, then acks the original entry, so the
+// application bounds the retries itself instead of relying on the pending
+// entries list. It honors WithRequeueStream and WithRequeueDelay. Run
+// neither acks nor counts as failed a task requeued by the run func,
+// whatever the run func returns. It returns ErrUnknownTask for a task
+// which isn't being processed by this worker.
+func (w *Worker) Requeue(task core.TaskMessage) error {
+	v, ok := w.pending.Load(task)
+	if !ok {
+		return ErrUnknownTask
+	}
+	message := v.(streamMessage)
+
+	values := make(map[string]interface{}, len(message.Values)+1)
+	for k, v := range message.Values {
+		values[k] = v
+	}
+	values[attemptsField] = attempts(message) + 1
+	retry := message
+	retry.Values = values
+
+	ctx := context.WithoutCancel(w.ctx)
+	if err := w.requeueValues(ctx, retry); err != nil {
+		w.handleError(err, ErrorContext{Stage: ErrorStageRequeue, Stream: message.stream, MessageID: message.ID})
+		return err
+	}
+	w.opts.metrics.IncRequeued()
+	atomic.AddInt64(&w.counters.requeued, 1)
+	w.requeued.Store(task, struct{}{})
+	// the copy carries the same dedup key
+	w.releaseDedup(ctx, message)
+
+	// the entry was acked on delivery otherwise
+	if !w.opts.ackOnSuccess || w.opts.noAck || !w.acking() {
+		return nil
+	}
+
+	return w.ack(ctx, message.stream, message.ID)
+}
@@ -0,0 +1,44 @@
+package redisdb
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff computes an exponential delay between consecutive failures
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt uint
+	// jitter picks a random delay up to the computed one so the workers
+	// failing together don't retry together
+	jitter bool
+}
+
+// next returns the delay before the next attempt
+func (b *backoff) next() time.Duration {
+	d := b.delay()
+	if b.jitter {
+		return time.Duration(rand.Int64N(int64(d) + 1))
+	}
+
+	return d
+}
+
+// delay returns the exponential delay and moves to the next attempt
+func (b *backoff) delay() time.Duration {
+	d := b.base << b.attempt
+	// the shift overflows long before the attempt counter does
+	if d <= 0 || d > b.max {
+		d = b.max
+	} else {
+		b.attempt++
+	}
+
+	return d
+}
+
+// reset starts over from the base delay
+func (b *backoff) reset() {
+	b.attempt = 0
+}
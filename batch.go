@@ -0,0 +1,35 @@
+package redisdb
+
+// adaptiveBatch tunes the read count between min and max, it doubles
+// after a full batch and halves after an empty read.
+type adaptiveBatch struct {
+	min  int64
+	max  int64
+	size int64
+}
+
+func newAdaptiveBatch(min, max int64) *adaptiveBatch {
+	return &adaptiveBatch{min: min, max: max, size: min}
+}
+
+// grow raises the size toward max, it reports whether it changed
+func (b *adaptiveBatch) grow() bool {
+	size := b.size * 2
+	if size > b.max {
+		size = b.max
+	}
+	changed := size != b.size
+	b.size = size
+	return changed
+}
+
+// shrink lowers the size toward min, it reports whether it changed
+func (b *adaptiveBatch) shrink() bool {
+	size := b.size / 2
+	if size < b.min {
+		size = b.min
+	}
+	changed := size != b.size
+	b.size = size
+	return changed
+}
@@ -0,0 +1,216 @@
+package redisdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang-queue/queue"
+	"github.com/redis/go-redis/v9"
+)
+
+// autoClaimCount is the max number of entries claimed by a single XAUTOCLAIM
+const autoClaimCount = 100
+
+// recoverPending re-delivers entries which were read by this consumer
+// but never acked, e.g. because the process crashed while handling them.
+// It returns false if the worker was stopped during the recovery.
+func (w *Worker) recoverPending(ctx context.Context, consumer string) bool {
+	for _, stream := range w.opts.streams {
+		if !w.recoverStream(ctx, stream, consumer) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *Worker) recoverStream(ctx context.Context, stream, consumer string) bool {
+	pending, err := w.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   stream,
+		Group:    w.opts.group,
+		Start:    "-",
+		End:      "+",
+		Count:    w.opts.recoverPendingCount,
+		Consumer: consumer,
+	}).Result()
+	if err != nil {
+		w.opts.logger.Errorf("can't read pending entries of %s: %v", stream, err)
+		return true
+	}
+	if len(pending) == 0 {
+		return true
+	}
+
+	ids := make([]string, 0, len(pending))
+	failures := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		ids = append(ids, p.ID)
+		failures[p.ID] = p.RetryCount
+	}
+
+	// claim the entries back to ourselves to fetch their content
+	messages, err := w.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    w.opts.group,
+		Consumer: consumer,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		w.opts.logger.Errorf("can't claim pending entries of %s: %v", stream, err)
+		return true
+	}
+
+	w.opts.logger.Infof("recover %d pending messages of %s", len(messages), stream)
+	return w.redeliver(ctx, stream, consumer, messages, failures)
+}
+
+// autoClaim periodically claims the messages which stayed idle in the
+// pending entries list of other consumers for longer than autoClaimMinIdle,
+// e.g. because the consumer died, and delivers them to this worker.
+func (w *Worker) autoClaim() {
+	ticker := time.NewTicker(w.opts.autoClaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, stream := range w.opts.streams {
+			if !w.autoClaimStream(w.ctx, stream) {
+				return
+			}
+		}
+	}
+}
+
+// autoClaimStream claims the idle messages of the stream page by page,
+// following the XAUTOCLAIM cursor until the whole pending entries list
+// was scanned.
+func (w *Worker) autoClaimStream(ctx context.Context, stream string) bool {
+	consumer := w.claimConsumer()
+	start := "0-0"
+	for {
+		messages, next, err := w.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    w.opts.group,
+			Consumer: consumer,
+			MinIdle:  w.opts.autoClaimMinIdle,
+			Start:    start,
+			Count:    autoClaimCount,
+		}).Result()
+		if err != nil {
+			w.opts.logger.Errorf("can't auto claim idle messages of %s: %v", stream, err)
+			return true
+		}
+
+		if len(messages) > 0 {
+			w.opts.logger.Infof("reclaimed %d idle messages of %s", len(messages), stream)
+			if !w.redeliverClaimed(ctx, stream, consumer, messages) {
+				return false
+			}
+		}
+
+		if next == "0-0" || next == "" {
+			return true
+		}
+		start = next
+	}
+}
+
+// redeliverClaimed delivers the messages reclaimed by XAUTOCLAIM
+func (w *Worker) redeliverClaimed(
+	ctx context.Context, stream, consumer string, messages []redis.XMessage,
+) bool {
+	// the claim already counted the upcoming delivery
+	failures := make(map[string]int64, len(messages))
+	if w.opts.maxRetries > 0 {
+		counts, err := w.deliveryCounts(ctx, stream, consumer, messages)
+		if err != nil {
+			w.opts.logger.Errorf("can't read delivery counts: %v", err)
+		}
+		for id, n := range counts {
+			failures[id] = n - 1
+		}
+	}
+
+	return w.redeliver(ctx, stream, consumer, messages, failures)
+}
+
+// Claim transfers the given messages of the main stream which stayed
+// idle for at least minIdle to this consumer and returns them, e.g. to
+// take over the pending messages of a dead consumer. The messages aren't
+// delivered to the worker, see ClaimAndDeliver.
+func (w *Worker) Claim(ctx context.Context, minIdle time.Duration, ids ...string) ([]redis.XMessage, error) {
+	return w.claim(ctx, w.claimConsumer(), minIdle, ids...)
+}
+
+func (w *Worker) claim(
+	ctx context.Context, consumer string, minIdle time.Duration, ids ...string,
+) ([]redis.XMessage, error) {
+	return w.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   w.opts.streamName,
+		Group:    w.opts.group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+}
+
+// ClaimJustID claims the messages like Claim with JUSTID, it returns
+// only the claimed IDs and doesn't increase their delivery count.
+func (w *Worker) ClaimJustID(ctx context.Context, minIdle time.Duration, ids ...string) ([]string, error) {
+	return w.rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   w.opts.streamName,
+		Group:    w.opts.group,
+		Consumer: w.claimConsumer(),
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+}
+
+// ClaimForce claims the messages like Claim with FORCE, a recovery tool
+// for a pending entries list in a broken state, e.g. entries whose
+// consumer was deleted. An ID missing from the pending entries list is
+// added to it as if it was delivered to this consumer, even if it never
+// was, as long as the message still exists in the stream. Redis ignores
+// the IDs of deleted messages.
+func (w *Worker) ClaimForce(ctx context.Context, minIdle time.Duration, ids ...string) ([]redis.XMessage, error) {
+	args := make([]interface{}, 0, 6+len(ids))
+	args = append(args, "xclaim", w.opts.streamName, w.opts.group, w.claimConsumer(), minIdle.Milliseconds())
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args = append(args, "force")
+
+	// go-redis has no FORCE argument, redis.Cmdable has no Do either
+	cmd := redis.NewXMessageSliceCmd(ctx, args...)
+	if _, err := w.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		return pipe.Process(ctx, cmd)
+	}); err != nil {
+		return nil, err
+	}
+
+	return cmd.Result()
+}
+
+// ClaimAndDeliver claims the messages like Claim and delivers them to the
+// worker, it blocks until they are all requested and returns
+// queue.ErrQueueShutdown if the worker stops meanwhile.
+func (w *Worker) ClaimAndDeliver(ctx context.Context, minIdle time.Duration, ids ...string) error {
+	consumer := w.claimConsumer()
+	messages, err := w.claim(ctx, consumer, minIdle, ids...)
+	if err != nil {
+		return err
+	}
+
+	if !w.redeliver(ctx, w.opts.streamName, consumer, messages, nil) {
+		return queue.ErrQueueShutdown
+	}
+
+	return nil
+}
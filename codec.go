@@ -0,0 +1,30 @@
+package redisdb
+
+import (
+	"encoding/json"
+
+	"github.com/golang-queue/queue/job"
+)
+
+// Codec encodes the tasks stored in the stream. The payload field keeps
+// the encoded bytes as they are, so a binary codec doesn't pay the
+// base64 overhead of JSON.
+type Codec interface {
+	Marshal(m *job.Message) ([]byte, error)
+	Unmarshal(data []byte, m *job.Message) error
+}
+
+var _ Codec = JSONCodec{}
+
+// JSONCodec encodes the tasks as JSON, it's the default
+type JSONCodec struct{}
+
+// Marshal encodes the task as JSON
+func (JSONCodec) Marshal(m *job.Message) ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// Unmarshal decodes the task from JSON
+func (JSONCodec) Unmarshal(data []byte, m *job.Message) error {
+	return json.Unmarshal(data, m)
+}
@@ -0,0 +1,95 @@
+package redisdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// compressionField holds the name of the compression of the payload,
+// the messages without it are stored uncompressed.
+const compressionField = "compression"
+
+// CompressionCodec compresses the payloads, its name is stored along
+// with each compressed message so mixed producers can be read.
+type CompressionCodec interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var _ CompressionCodec = GzipCompression{}
+
+// GzipCompression compresses the payloads with gzip
+type GzipCompression struct {
+	// Level is the gzip level, zero uses the default compression
+	Level int
+}
+
+// Name returns "gzip"
+func (GzipCompression) Name() string {
+	return "gzip"
+}
+
+// Compress compresses the data with gzip
+func (c GzipCompression) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses gzip data
+func (GzipCompression) Decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return io.ReadAll(zr)
+}
+
+// compress compresses the payload above the threshold, it returns the
+// name of the compression or an empty name if it's left as is.
+func (w *Worker) compress(body []byte) ([]byte, string, error) {
+	if w.opts.compression == nil || len(body) < w.opts.compressionThreshold {
+		return body, "", nil
+	}
+
+	data, err := w.opts.compression.Compress(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, w.opts.compression.Name(), nil
+}
+
+// decompress reverses the compression named by the message
+func (w *Worker) decompress(body []byte, name string) ([]byte, error) {
+	switch {
+	case name == "":
+		return body, nil
+	case w.opts.compression != nil && w.opts.compression.Name() == name:
+		return w.opts.compression.Decompress(body)
+	case name == GzipCompression{}.Name():
+		return GzipCompression{}.Decompress(body)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", name)
+	}
+}
@@ -0,0 +1,139 @@
+package redisdb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// deadLetterField is the field holding the dead-letter metadata
+const deadLetterField = "dead_letter"
+
+// deadLetterMeta records why a message was moved to the dead-letter stream
+type deadLetterMeta struct {
+	ID       string `json:"id"`
+	Failures int64  `json:"failures"`
+	Error    string `json:"error,omitempty"`
+}
+
+// exhausted reports whether a message which failed the given number of
+// times ran out of retries and should be dead-lettered.
+func (w *Worker) exhausted(failures int64) bool {
+	return w.opts.maxRetries > 0 && failures > int64(w.opts.maxRetries)
+}
+
+func (w *Worker) deadLetterStream(stream string) string {
+	if w.opts.deadLetterStream != "" {
+		return w.opts.deadLetterStream
+	}
+	return stream + ":dead-letter"
+}
+
+// deadLetter moves the message to the dead-letter stream and acks it
+// off the main stream, cause tells why it was rejected if it wasn't
+// because of the failures.
+func (w *Worker) deadLetter(ctx context.Context, message streamMessage, failures int64, cause error) error {
+	meta := deadLetterMeta{
+		ID:       message.ID,
+		Failures: failures,
+	}
+	if cause != nil {
+		meta.Error = cause.Error()
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{}, len(message.Values)+1)
+	for k, v := range message.Values {
+		values[k] = v
+	}
+	values[deadLetterField] = string(data)
+
+	if err := w.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: w.deadLetterStream(message.stream),
+		Values: values,
+	}).Err(); err != nil {
+		return err
+	}
+	w.opts.metrics.IncDeadLettered()
+
+	return w.ack(ctx, message.stream, message.ID)
+}
+
+// deliveryCounts returns the delivery counter of the given pending entries
+// of the consumer. The range between the first and the last message may
+// hold other entries, so it's paged until every message was seen.
+func (w *Worker) deliveryCounts(
+	ctx context.Context, stream, consumer string, messages []redis.XMessage,
+) (map[string]int64, error) {
+	counts := make(map[string]int64, len(messages))
+	if len(messages) == 0 {
+		return counts, nil
+	}
+
+	wanted := make(map[string]struct{}, len(messages))
+	for _, m := range messages {
+		wanted[m.ID] = struct{}{}
+	}
+
+	start := messages[0].ID
+	count := int64(len(messages))
+	for {
+		pending, err := w.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   stream,
+			Group:    w.opts.group,
+			Start:    start,
+			End:      messages[len(messages)-1].ID,
+			Count:    count,
+			Consumer: consumer,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range pending {
+			if _, ok := wanted[p.ID]; ok {
+				counts[p.ID] = p.RetryCount
+			}
+		}
+
+		if len(counts) == len(wanted) || int64(len(pending)) < count {
+			return counts, nil
+		}
+		start = "(" + pending[len(pending)-1].ID
+	}
+}
+
+// redeliver delivers messages coming back from the pending entries list
+// of the consumer, failures holds the number of previous deliveries of
+// each message.
+// Messages which ran out of retries go to the dead-letter stream instead.
+// It returns false once the worker is stopping.
+func (w *Worker) redeliver(
+	ctx context.Context, stream, consumer string, messages []redis.XMessage, failures map[string]int64,
+) bool {
+	for _, m := range messages {
+		w.countRead(stream)
+		// a message of the pending entries list was delivered before
+		message := streamMessage{m, stream, max(failures[m.ID], 1), consumer}
+		if n := failures[message.ID]; w.exhausted(n) {
+			w.messageLogger(message).Info("move message to dead-letter stream", "failures", n)
+			if err := w.deadLetter(ctx, message, n, nil); err != nil {
+				w.messageLogger(message).Error("can't dead-letter message", "error", err)
+			}
+			continue
+		}
+
+		// once stopped the message is re-queued, the rest stays in the
+		// pending entries list with its delivery count and is recovered
+		// later
+		if !w.deliver(ctx, message) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,83 @@
+package redisdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// dedupKey returns the dedup key of the message, empty when
+// deduplication is disabled or the message doesn't carry one.
+func (w *Worker) dedupKey(message streamMessage) string {
+	if w.opts.dedupKey == "" {
+		return ""
+	}
+	key, _ := message.Values[w.opts.dedupKey].(string)
+	return key
+}
+
+// dedupRedisKey is the redis key recording a processed dedup key
+func dedupRedisKey(stream, key string) string {
+	return stream + ":dedup:" + key
+}
+
+// processed claims the dedup key of the message and reports whether a
+// message with the same key was already processed or is being processed.
+// The claim is atomic so two copies delivered at once don't both run, the
+// message holding it, e.g. delivered again from the pending entries list,
+// isn't a duplicate. A failing lookup delivers the message anyway.
+func (w *Worker) processed(ctx context.Context, message streamMessage) bool {
+	key := w.dedupKey(message)
+	if key == "" {
+		return false
+	}
+
+	redisKey := dedupRedisKey(message.stream, key)
+	claimed, err := w.rdb.SetNX(ctx, redisKey, message.ID, w.opts.dedupTTL).Result()
+	if err != nil {
+		w.opts.logger.Errorf("can't check dedup key %s: %s", key, err.Error())
+		return false
+	}
+	if claimed {
+		return false
+	}
+
+	owner, err := w.rdb.Get(ctx, redisKey).Result()
+	if errors.Is(err, redis.Nil) {
+		// expired since the claim failed
+		return false
+	}
+	if err != nil {
+		w.opts.logger.Errorf("can't check dedup key %s: %s", key, err.Error())
+		return false
+	}
+
+	return owner != message.ID
+}
+
+// releaseDedup drops the claim of the dedup key of a message which wasn't
+// processed, e.g. failed or re-queued, so a later copy runs.
+func (w *Worker) releaseDedup(ctx context.Context, message streamMessage) {
+	key := w.dedupKey(message)
+	if key == "" {
+		return
+	}
+
+	if err := w.rdb.Del(ctx, dedupRedisKey(message.stream, key)).Err(); err != nil {
+		w.opts.logger.Errorf("can't release dedup key %s: %s", key, err.Error())
+	}
+}
+
+// markProcessed records the dedup key of a processed message, it
+// expires after the dedup TTL.
+func (w *Worker) markProcessed(ctx context.Context, message streamMessage) {
+	key := w.dedupKey(message)
+	if key == "" {
+		return
+	}
+
+	if err := w.rdb.Set(ctx, dedupRedisKey(message.stream, key), message.ID, w.opts.dedupTTL).Err(); err != nil {
+		w.opts.logger.Errorf("can't record dedup key %s: %s", key, err.Error())
+	}
+}
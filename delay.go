@@ -0,0 +1,200 @@
+package redisdb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-queue/queue"
+	"github.com/golang-queue/queue/core"
+
+	"github.com/appleboy/com/bytesconv"
+	"github.com/redis/go-redis/v9"
+)
+
+// delayedBatch is the number of due entries promoted per stream on each tick
+const delayedBatch = 100
+
+// delayedEntry is a member of the delayed sorted set, the nonce keeps
+// identical payloads apart.
+type delayedEntry struct {
+	Nonce  string                 `json:"nonce"`
+	Values map[string]interface{} `json:"values"`
+	// Binary reports the payload field is base64 encoded
+	Binary bool `json:"binary,omitempty"`
+}
+
+// decodeBody decodes the binary payload field
+func (e *delayedEntry) decodeBody(field string) error {
+	body, ok := e.Values[field].(string)
+	if !e.Binary || !ok {
+		return nil
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return err
+	}
+	e.Values[field] = payload
+	return nil
+}
+
+// delayedKey is the sorted set holding the delayed messages of a stream
+func delayedKey(stream string) string {
+	return stream + ":delayed"
+}
+
+// QueueWithDelay sends the task once the delay elapsed. The message is
+// kept in a sorted set scored by its delivery time until a worker with
+// WithDelayedDelivery moves it to the stream, a non-positive delay
+// queues it right away.
+func (w *Worker) QueueWithDelay(task core.TaskMessage, delay time.Duration) error {
+	if delay <= 0 {
+		return w.Queue(task)
+	}
+
+	if atomic.LoadInt32(&w.stopFlag) == 1 {
+		return queue.ErrQueueShutdown
+	}
+
+	values, err := w.values(task)
+	if err != nil {
+		return err
+	}
+
+	return w.schedule(w.ctx, w.opts.streamName, values, delay)
+}
+
+// schedule adds the message to the delayed sorted set of the stream
+func (w *Worker) schedule(ctx context.Context, stream string, values map[string]interface{}, delay time.Duration) error {
+	member, err := w.delayedMember(values)
+	if err != nil {
+		return err
+	}
+
+	return w.rdb.ZAdd(ctx, delayedKey(stream), redis.Z{
+		Score:  float64(time.Now().Add(delay).UnixMilli()),
+		Member: member,
+	}).Err()
+}
+
+// delayedMember encodes the message as a member of the delayed sorted set.
+// JSON only holds valid UTF-8 strings, so the payload is base64 encoded
+// whatever produced it, e.g. a compressed or a binary codec payload.
+func (w *Worker) delayedMember(values map[string]interface{}) (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	entry := delayedEntry{
+		Nonce:  hex.EncodeToString(nonce),
+		Values: values,
+	}
+	if body, ok := w.bodyBytes(values[w.opts.payloadField]); ok {
+		entry.Values = make(map[string]interface{}, len(values))
+		for k, v := range values {
+			entry.Values[k] = v
+		}
+		entry.Values[w.opts.payloadField] = base64.StdEncoding.EncodeToString(body)
+		entry.Binary = true
+	}
+	member, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	return bytesconv.BytesToStr(member), nil
+}
+
+// delayedValues decodes a member of the delayed sorted set into the
+// values of its stream entry
+func (w *Worker) delayedValues(member string) (map[string]interface{}, error) {
+	var entry delayedEntry
+	if err := json.Unmarshal([]byte(member), &entry); err != nil {
+		return nil, err
+	}
+	if err := entry.decodeBody(w.opts.payloadField); err != nil {
+		return nil, err
+	}
+
+	return entry.Values, nil
+}
+
+// promoteDelayed moves the due delayed messages to their stream on
+// every poll interval.
+func (w *Worker) promoteDelayed() {
+	ticker := time.NewTicker(w.opts.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, stream := range w.opts.streams {
+			n, err := w.promote(w.ctx, stream)
+			if err != nil {
+				w.opts.logger.Errorf("can't promote delayed messages of %s: %v", stream, err)
+				continue
+			}
+			if n > 0 {
+				w.opts.logger.Infof("promoted %d delayed messages of %s", n, stream)
+			}
+		}
+	}
+}
+
+// promote adds the due entries to the stream. An entry is claimed by
+// removing it from the sorted set first so concurrent workers don't
+// promote it twice, it's put back if the XADD fails.
+func (w *Worker) promote(ctx context.Context, stream string) (int, error) {
+	key := delayedKey(stream)
+	due, err := w.rdb.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: delayedBatch,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+	for _, member := range due {
+		removed, err := w.rdb.ZRem(ctx, key, member).Result()
+		if err != nil {
+			return promoted, err
+		}
+		// another worker claimed it
+		if removed == 0 {
+			continue
+		}
+
+		values, err := w.delayedValues(member)
+		if err != nil {
+			w.opts.logger.Errorf("drop invalid delayed message of %s: %v", stream, err)
+			continue
+		}
+
+		if err := w.queue(context.WithoutCancel(ctx), stream, values); err != nil {
+			if err := w.rdb.ZAdd(context.WithoutCancel(ctx), key, redis.Z{
+				Score:  float64(time.Now().UnixMilli()),
+				Member: member,
+			}).Err(); err != nil {
+				w.opts.logger.Errorf("lost delayed message of %s: %v", stream, err)
+			}
+			return promoted, err
+		}
+		promoted++
+	}
+
+	return promoted, nil
+}
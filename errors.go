@@ -0,0 +1,88 @@
+package redisdb
+
+import "errors"
+
+var (
+	// ErrInvalidConnectionString is returned when the connection string can't be parsed
+	ErrInvalidConnectionString = errors.New("invalid redis connection string")
+	// ErrPing is returned when redis doesn't answer the startup ping
+	ErrPing = errors.New("can't ping redis")
+	// ErrConnectTimeout is returned when the startup ping exceeds the connect timeout
+	ErrConnectTimeout = errors.New("timed out connecting to redis")
+	// ErrInvalidPayload is returned when a message has no readable payload field
+	ErrInvalidPayload = errors.New("missing or invalid payload field")
+	// ErrDecodePayload is returned when the payload can't be decoded into a task
+	ErrDecodePayload = errors.New("can't decode payload")
+	// ErrInvalidStartID is returned when the start ID isn't "$", "0" or a stream ID
+	ErrInvalidStartID = errors.New("invalid start id")
+	// ErrTaskPanic is returned by Run when the run func panics
+	ErrTaskPanic = errors.New("task panicked")
+	// ErrInvalidBlockTime is returned for a negative block time or a non-positive poll interval
+	ErrInvalidBlockTime = errors.New("invalid block time")
+	// ErrInvalidBackoff is returned when the retry backoff isn't 0 < base <= max
+	ErrInvalidBackoff = errors.New("invalid retry backoff")
+	// ErrTrimConflict is returned when both a max length and a min ID are set
+	ErrTrimConflict = errors.New("max length and min id trimming can't be combined")
+	// ErrInvalidTrimStrategy is returned when the periodic trim strategy would empty the streams
+	ErrInvalidTrimStrategy = errors.New("invalid trim strategy")
+	// ErrInvalidMaxLen is returned when the max length is negative
+	ErrInvalidMaxLen = errors.New("invalid max length")
+	// ErrInvalidMinID is returned when the min ID isn't a stream ID
+	ErrInvalidMinID = errors.New("invalid min id")
+	// ErrGroupNotFound is returned when the consumer group doesn't exist
+	ErrGroupNotFound = errors.New("consumer group not found")
+	// ErrLagUnsupported is returned by Lag when redis doesn't report the group lag
+	ErrLagUnsupported = errors.New("consumer group lag requires redis 7 or later")
+	// ErrLagUnknown is returned by Lag when redis can't compute the lag of a group
+	ErrLagUnknown = errors.New("consumer group lag unknown")
+	// ErrInvalidTLSConfig is returned when the TLS files can't be loaded
+	ErrInvalidTLSConfig = errors.New("invalid tls configuration")
+	// ErrProcessTimeout is returned by Run when a task exceeds the process timeout
+	ErrProcessTimeout = errors.New("task processing timed out")
+	// ErrInvalidDB is returned for a negative database index
+	ErrInvalidDB = errors.New("invalid redis db index")
+	// ErrInvalidDedupTTL is returned for a negative dedup TTL
+	ErrInvalidDedupTTL = errors.New("invalid dedup ttl")
+	// ErrStaleMessageID is returned when an explicit ID isn't greater than the last stream entry
+	ErrStaleMessageID = errors.New("message id is equal or smaller than the last stream entry")
+	// ErrStreamNotFound is returned with WithNoMkStream or WithProduceNoMkStream when a stream doesn't exist
+	ErrStreamNotFound = errors.New("stream not found")
+	// ErrReservedField is returned when an extra field collides with a field of the worker
+	ErrReservedField = errors.New("reserved stream field")
+	// ErrPayloadTooLarge is returned when a payload exceeds the max payload size
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrInvalidAdaptiveBatch is returned when the adaptive batch isn't 0 < min <= max
+	ErrInvalidAdaptiveBatch = errors.New("invalid adaptive batch")
+	// ErrNoGroups is returned when a group manager is created without groups
+	ErrNoGroups = errors.New("no consumer groups")
+	// ErrInvalidMessage is returned when a message is rejected by the message validator
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrInvalidReadCount is returned when the read count isn't positive
+	ErrInvalidReadCount = errors.New("invalid read count")
+	// ErrInvalidConcurrency is returned when the number of read loops isn't positive
+	ErrInvalidConcurrency = errors.New("invalid concurrency")
+	// ErrInvalidAckBatch is returned when the ack batch size isn't positive or its flush interval is negative
+	ErrInvalidAckBatch = errors.New("invalid ack batch")
+	// ErrInvalidRateLimit is returned when the rate limit is negative or not a finite number
+	ErrInvalidRateLimit = errors.New("invalid rate limit")
+	// ErrInvalidTaskBuffer is returned when the task buffer size is negative
+	ErrInvalidTaskBuffer = errors.New("invalid task buffer")
+	// ErrWrongType is returned when a stream name points to a key which isn't a stream
+	ErrWrongType = errors.New("key is not a stream")
+	// ErrInvalidPool is returned when a connection pool setting is negative
+	ErrInvalidPool = errors.New("invalid connection pool settings")
+	// ErrAlreadyStarted is returned by RunToCompletion once the worker started reading
+	ErrAlreadyStarted = errors.New("worker already started")
+	// ErrInvalidProduceBatch is returned when a produce batch threshold is negative
+	ErrInvalidProduceBatch = errors.New("invalid produce batch")
+	// ErrMessageNotFound is returned by Fetch for the IDs which aren't in the streams
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrUnknownTask is returned by Requeue for a task which isn't being processed
+	ErrUnknownTask = errors.New("unknown task")
+	// ErrInvalidPELThreshold is returned when the PEL threshold or its check interval isn't positive
+	ErrInvalidPELThreshold = errors.New("invalid pel threshold")
+	// ErrSentinelCredentials is returned when a credentials provider is combined with sentinel
+	ErrSentinelCredentials = errors.New("credentials provider not supported with sentinel")
+	// ErrMissingAddr is returned when neither an address nor a connection string is set
+	ErrMissingAddr = errors.New("missing redis address")
+)
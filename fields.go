@@ -0,0 +1,38 @@
+package redisdb
+
+import "context"
+
+// fieldsKey is the context key of the extra fields of a message
+type fieldsKey struct{}
+
+// deliveryKey is the context key of the delivery count of a message
+type deliveryKey struct{}
+
+// fields returns the stream fields of the message besides the payload
+func (w *Worker) fields(message streamMessage) map[string]interface{} {
+	fields := make(map[string]interface{}, len(message.Values))
+	for k, v := range message.Values {
+		if k == w.opts.payloadField || k == compressionField || k == payloadRefField || k == attemptsField {
+			continue
+		}
+		fields[k] = v
+	}
+
+	return fields
+}
+
+// FieldsFromContext returns the extra stream fields of the task being
+// processed, see QueueWithFields. The values read from redis are strings.
+func FieldsFromContext(ctx context.Context) map[string]interface{} {
+	fields, _ := ctx.Value(fieldsKey{}).(map[string]interface{})
+	return fields
+}
+
+// DeliveryFromContext returns how many times the task being processed was
+// delivered, this delivery included, and whether it's a redelivery, e.g.
+// a message recovered from the pending entries list after a crash. The
+// count is zero if it isn't known.
+func DeliveryFromContext(ctx context.Context) (deliveryCount int, redelivered bool) {
+	deliveryCount, _ = ctx.Value(deliveryKey{}).(int)
+	return deliveryCount, deliveryCount > 1
+}
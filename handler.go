@@ -0,0 +1,27 @@
+package redisdb
+
+// ErrorStage is the step of the message lifecycle which failed
+type ErrorStage string
+
+// error stages reported to the error handler
+const (
+	ErrorStageRead    ErrorStage = "read"
+	ErrorStageAck     ErrorStage = "ack"
+	ErrorStageRequeue ErrorStage = "requeue"
+	ErrorStageGroup   ErrorStage = "group"
+)
+
+// ErrorContext describes where an error reported to the error handler
+// happened, MessageID is empty when the error isn't tied to a message.
+type ErrorContext struct {
+	Stage     ErrorStage
+	Stream    string
+	MessageID string
+}
+
+// handleError passes the error to the error handler if any
+func (w *Worker) handleError(err error, ctx ErrorContext) {
+	if w.opts.errorHandler != nil {
+		w.opts.errorHandler(err, ctx)
+	}
+}
@@ -0,0 +1,56 @@
+package redisdb
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idFromTime returns the first stream ID which can be generated at t
+func idFromTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10) + "-0"
+}
+
+// isStreamID reports whether id is a well-formed stream entry ID,
+// either "<ms>" or "<ms>-<seq>"
+func isStreamID(id string) bool {
+	ms, seq, found := strings.Cut(id, "-")
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return false
+	}
+	if !found {
+		return true
+	}
+	_, err := strconv.ParseUint(seq, 10, 64)
+	return err == nil
+}
+
+// enqueuedAtKey is the context key of the enqueue time of a message
+type enqueuedAtKey struct{}
+
+// EnqueuedAt returns the time encoded in the millisecond part of a stream
+// ID generated by redis, which is when the message was added to the
+// stream. It returns the zero time for a malformed ID or a zero
+// millisecond part. An explicit ID, e.g. set with WithExplicitID, only
+// holds the time chosen by the producer.
+func EnqueuedAt(msgID string) time.Time {
+	if !isStreamID(msgID) {
+		return time.Time{}
+	}
+	ms, _, _ := strings.Cut(msgID, "-")
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(n)
+}
+
+// EnqueuedAtFromContext returns the enqueue time of the task being
+// processed, see EnqueuedAt, e.g. to measure how long it waited in the
+// stream. It returns false if the time isn't known.
+func EnqueuedAtFromContext(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(enqueuedAtKey{}).(time.Time)
+	return t, ok
+}
@@ -0,0 +1,62 @@
+package redisdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang-queue/queue"
+)
+
+// StructuredLogger logs messages along with key/value fields, it can
+// wrap slog, zap or logrus so the fields are queryable.
+type StructuredLogger interface {
+	// With returns a logger adding the key/value pairs to every entry
+	With(kv ...interface{}) StructuredLogger
+	Info(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// fieldLogger is the default StructuredLogger, it appends the fields
+// as key=value pairs to the messages of a queue.Logger.
+type fieldLogger struct {
+	logger queue.Logger
+	fields []interface{}
+}
+
+func newFieldLogger(logger queue.Logger) StructuredLogger {
+	return fieldLogger{logger: logger}
+}
+
+func (l fieldLogger) With(kv ...interface{}) StructuredLogger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return fieldLogger{logger: l.logger, fields: fields}
+}
+
+func (l fieldLogger) Info(msg string, kv ...interface{}) {
+	l.logger.Info(l.format(msg, kv))
+}
+
+func (l fieldLogger) Error(msg string, kv ...interface{}) {
+	l.logger.Error(l.format(msg, kv))
+}
+
+func (l fieldLogger) format(msg string, kv []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 < len(fields) {
+			fmt.Fprintf(&b, " %v=%v", fields[i], fields[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", fields[i])
+		}
+	}
+	return b.String()
+}
+
+// messageLogger returns the structured logger with the fields of the message
+func (w *Worker) messageLogger(message streamMessage) StructuredLogger {
+	return w.opts.structuredLogger.With("stream", message.stream, "message_id", message.ID)
+}
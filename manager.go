@@ -0,0 +1,96 @@
+package redisdb
+
+import (
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// GroupManager runs a worker per consumer group over the same streams,
+// sharing a single redis client, e.g. to fan a stream out to several
+// independent pipelines.
+type GroupManager struct {
+	workers []*Worker
+	groups  map[string]*Worker
+	// client is the shared client, nil when it was injected with WithClient
+	client redis.Cmdable
+}
+
+// NewGroupManager creates a worker for each group with the given options,
+// WithGroup is overridden by the group names. The workers share a client
+// which is closed by the Shutdown of the manager, so the workers must be
+// stopped through it. Each worker reads through a small client of its own
+// so its Shutdown aborts the blocking reads, unless the client was
+// injected with WithClient.
+func NewGroupManager(groups []string, opts ...Option) (*GroupManager, error) {
+	if len(groups) == 0 {
+		return nil, ErrNoGroups
+	}
+
+	m := &GroupManager{groups: make(map[string]*Worker, len(groups))}
+	base := newOptions(opts...)
+	client := base.client
+	if client == nil {
+		rdb, err := base.newClient()
+		if err != nil {
+			return nil, err
+		}
+		client = rdb
+		m.client = rdb
+	}
+
+	for _, group := range groups {
+		groupOpts := make([]Option, 0, len(opts)+3)
+		groupOpts = append(groupOpts, opts...)
+		groupOpts = append(groupOpts, WithClient(client), WithGroup(group))
+		// a reader per worker lets Shutdown abort its blocking reads
+		var reader redis.Cmdable
+		if m.client != nil {
+			rdb, err := base.newReaderClient()
+			if err != nil {
+				_ = m.Shutdown()
+				return nil, err
+			}
+			reader = rdb
+			groupOpts = append(groupOpts, withReaderClient(reader))
+		}
+		w, err := NewWorkerWithError(groupOpts...)
+		if err != nil {
+			if reader != nil {
+				closeClient(reader)
+			}
+			_ = m.Shutdown()
+			return nil, err
+		}
+		m.workers = append(m.workers, w)
+		m.groups[group] = w
+	}
+
+	return m, nil
+}
+
+// Worker returns the worker of the group, nil if it isn't managed
+func (m *GroupManager) Worker(group string) *Worker {
+	return m.groups[group]
+}
+
+// Workers returns the workers in the order of the groups
+func (m *GroupManager) Workers() []*Worker {
+	return m.workers
+}
+
+// Shutdown stops every worker, then closes the shared client
+func (m *GroupManager) Shutdown() error {
+	var errs []error
+	for _, w := range m.workers {
+		if err := w.Shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if m.client != nil {
+		closeClient(m.client)
+	}
+
+	return errors.Join(errs...)
+}
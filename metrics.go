@@ -0,0 +1,45 @@
+package redisdb
+
+import "time"
+
+// Metrics receives the processing events of the worker, e.g. to export
+// them as prometheus counters
+type Metrics interface {
+	// IncRead is called for each message read from redis
+	IncRead()
+	// IncAck is called for each acked message
+	IncAck()
+	// IncFailed is called when the run func returns an error
+	IncFailed()
+	// IncRequeued is called for each message added back to its stream
+	IncRequeued()
+	// IncDeadLettered is called for each message moved to the dead-letter stream
+	IncDeadLettered()
+	// ObserveLatency is called with the processing time of each task
+	ObserveLatency(d time.Duration)
+}
+
+// StreamMetrics can be implemented by a Metrics to also receive the
+// events labeled by stream name, e.g. to find a backlogged stream among
+// the ones of WithStreams. It's called along with the Metrics methods.
+type StreamMetrics interface {
+	// IncStreamRead is called for each message read from the stream
+	IncStreamRead(stream string)
+	// IncStreamAck is called for each message acked on the stream
+	IncStreamAck(stream string)
+	// IncStreamFailed is called when the run func returns an error for
+	// a message of the stream
+	IncStreamFailed(stream string)
+}
+
+var _ Metrics = emptyMetrics{}
+
+// emptyMetrics discards every event, it's the default
+type emptyMetrics struct{}
+
+func (emptyMetrics) IncRead()                     {}
+func (emptyMetrics) IncAck()                      {}
+func (emptyMetrics) IncFailed()                   {}
+func (emptyMetrics) IncRequeued()                 {}
+func (emptyMetrics) IncDeadLettered()             {}
+func (emptyMetrics) ObserveLatency(time.Duration) {}
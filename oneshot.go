@@ -0,0 +1,159 @@
+package redisdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RunToCompletion processes the messages available in the streams with
+// the run func, then returns once a read comes back empty instead of
+// waiting for new messages, e.g. for a scheduled job handling whatever
+// accumulated since its last run. It can't be combined with Request: it
+// returns ErrAlreadyStarted once the worker started reading, and Request
+// doesn't read anything after it. With WithAckOnSuccess a failed message
+// stays pending like with Run, otherwise it was acked on delivery. The
+// returned error tells how many failed. The acks of WithAckBatch are
+// flushed before it returns. With WithoutConsumerGroup the streams are
+// read with XREAD from the start ID.
+func (w *Worker) RunToCompletion(ctx context.Context) (err error) {
+	started := false
+	w.startOnce.Do(func() { started = true })
+	if !started {
+		return ErrAlreadyStarted
+	}
+	// nothing runs in the background, Shutdown doesn't have to wait
+	defer close(w.exit)
+	// the caller may exit right after, the batched acks can't wait
+	defer func() {
+		if !w.batchAcks() {
+			return
+		}
+		if flushErr := w.flushAcks(context.WithoutCancel(ctx)); flushErr != nil && err == nil {
+			err = fmt.Errorf("can't flush acks: %w", flushErr)
+		}
+	}()
+
+	streams := make([]string, 0, 2*len(w.opts.streams))
+	streams = append(streams, w.opts.streams...)
+	for range w.opts.streams {
+		streams = append(streams, ">")
+	}
+	ids := streams[len(w.opts.streams):]
+
+	// without a group the position is tracked locally
+	if w.opts.noGroup {
+		w.tailIDs(ctx, ids)
+	} else {
+		w.createGroups(ctx)
+	}
+
+	var total, failed int
+	var firstErr error
+	for {
+		if atomic.LoadInt32(&w.stopFlag) == 1 {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var consumer string
+		if !w.opts.noGroup {
+			consumer = w.claimConsumer()
+		}
+		data, err := w.readAvailable(ctx, consumer, streams)
+		if errors.Is(err, redis.Nil) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, result := range data {
+			for _, m := range result.Messages {
+				w.countRead(result.Stream)
+				total++
+				if err := w.runMessage(ctx, streamMessage{m, result.Stream, 0, consumer}); err != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
+			}
+			// the next tail read starts after the last entry
+			if w.opts.noGroup && len(result.Messages) > 0 {
+				for i, stream := range w.opts.streams {
+					if stream == result.Stream {
+						ids[i] = result.Messages[len(result.Messages)-1].ID
+					}
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d messages failed: %w", failed, total, firstErr)
+	}
+	return nil
+}
+
+// readAvailable reads the next batch of messages without waiting for new
+// entries, it returns redis.Nil once the streams are drained.
+func (w *Worker) readAvailable(ctx context.Context, consumer string, streams []string) ([]redis.XStream, error) {
+	// a negative block doesn't wait for new entries
+	if w.opts.noGroup {
+		return w.rdb.XRead(ctx, &redis.XReadArgs{
+			Streams: streams,
+			Count:   int64(w.opts.readCount),
+			Block:   -1,
+		}).Result()
+	}
+
+	return w.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    w.opts.group,
+		Consumer: consumer,
+		Streams:  streams,
+		Count:    int64(w.opts.readCount),
+		Block:    -1,
+		NoAck:    w.opts.noAck,
+	}).Result()
+}
+
+// runMessage processes a message read by RunToCompletion, acked like a
+// message delivered to Request.
+func (w *Worker) runMessage(ctx context.Context, message streamMessage) error {
+	if w.processed(ctx, message) {
+		w.messageLogger(message).Info("skip the duplicate task")
+		if !w.opts.noAck {
+			return w.ack(ctx, message.stream, message.ID)
+		}
+		return nil
+	}
+
+	if !w.opts.ackOnSuccess && !w.opts.noAck && w.acking() {
+		if err := w.ack(ctx, message.stream, message.ID); err != nil {
+			w.messageLogger(message).Error("can't ack message", "error", err)
+		}
+	}
+
+	atomic.AddInt64(&w.inFlight, 1)
+	task, err := w.decodeTask(message)
+	if errors.Is(err, ErrInvalidMessage) {
+		w.reject(message, err)
+		return nil
+	}
+	if err != nil {
+		atomic.AddInt64(&w.inFlight, -1)
+		return err
+	}
+	w.pending.Store(task, message)
+
+	// nothing calls Run again, the mapping kept for a retry is dropped
+	err = w.Run(ctx, task)
+	w.done(task)
+	return err
+}
@@ -2,11 +2,22 @@ package redisdb
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
 	"time"
 
 	"github.com/golang-queue/queue"
 	"github.com/golang-queue/queue/core"
+	"github.com/golang-queue/queue/job"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // Option for queue system
@@ -25,8 +36,150 @@ type options struct {
 	group            string
 	consumer         string
 	maxLength        int64
+	minID            string
+	approx           bool
 	blockTime        time.Duration
+	pollInterval     time.Duration
 	tls              *tls.Config
+	ackOnSuccess     bool
+
+	recoverPending      bool
+	recoverPendingCount int64
+
+	autoClaimInterval time.Duration
+	autoClaimMinIdle  time.Duration
+
+	maxRetries       int
+	deadLetterStream string
+
+	readCount int
+
+	connectTimeout time.Duration
+	skipPing       bool
+
+	client redis.Cmdable
+	// readerClient is the client of the blocking reads along with an
+	// injected client, owned by the worker
+	readerClient redis.Cmdable
+
+	masterName       string
+	sentinelAddrs    []string
+	sentinelPassword string
+
+	payloadField string
+
+	startID string
+
+	streams []string
+
+	shutdownTimeout time.Duration
+	requestTimeout  time.Duration
+
+	metrics Metrics
+
+	panicRecovery bool
+
+	ctx context.Context
+
+	retryBase     time.Duration
+	retryMax      time.Duration
+	backoffJitter bool
+
+	trimInterval time.Duration
+	trimStrategy TrimStrategy
+
+	concurrency int
+
+	noAck bool
+
+	deleteConsumerOnShutdown bool
+
+	codec Codec
+
+	processTimeout time.Duration
+
+	credentialsProvider func() (username string, password string)
+
+	dedupKey string
+	dedupTTL time.Duration
+
+	structuredLogger StructuredLogger
+
+	delayedDelivery bool
+
+	ackBatchSize     int
+	ackFlushInterval time.Duration
+
+	autoConsumerPrefix string
+
+	explicitID func(data interface{}) string
+
+	recreateGroup bool
+
+	errorHandler func(err error, ctx ErrorContext)
+
+	compression          CompressionCodec
+	compressionThreshold int
+
+	noMkStream bool
+
+	logEmptyReads bool
+
+	claimOwnPending bool
+
+	rateLimit float64
+
+	maxInFlight int
+
+	startTime time.Time
+
+	produceNoMkStream bool
+
+	maxPayloadBytes     int
+	largePayloadHandler LargePayloadHandler
+
+	requeueDelay  time.Duration
+	requeueStream string
+
+	messageValidator func(raw []byte) error
+
+	taskBuffer int
+
+	tracerProvider trace.TracerProvider
+
+	ackAttempts int
+
+	noGroup bool
+
+	unmarshalFunc func([]byte) (*job.Message, error)
+
+	priority         bool
+	priorityFairness int
+
+	poolSize        int
+	minIdleConns    int
+	connMaxLifetime time.Duration
+
+	adaptiveBatchMin int
+	adaptiveBatchMax int
+
+	consumerFunc func() string
+
+	publishAttempts int
+	publishBackoff  time.Duration
+
+	binaryBody bool
+
+	pelThreshold        int
+	pelThresholdHandler func(count int)
+	pelCheckInterval    time.Duration
+
+	produceBatchCount    int
+	produceBatchBytes    int
+	produceBatchInterval time.Duration
+
+	// tlsErr holds the error of WithTLSFromFiles until validation
+	tlsErr error
 }
 
 // WithAddr setup the addr of redis
@@ -36,36 +189,197 @@ func WithAddr(addr string) Option {
 	}
 }
 
-// WithMaxLength setup the max length for publish messages
+// WithClient uses the given client instead of creating one from the
+// address or connection string options. The worker doesn't close an
+// injected client on shutdown, it's up to the caller, so Shutdown can't
+// abort a blocking read either: the read loop returns once it completes.
+func WithClient(rdb redis.Cmdable) Option {
+	return func(w *options) {
+		w.client = rdb
+	}
+}
+
+// withReaderClient gives a worker sharing an injected client a reader of
+// its own, closed by Shutdown to abort the blocking reads
+func withReaderClient(rdb redis.Cmdable) Option {
+	return func(w *options) {
+		w.readerClient = rdb
+	}
+}
+
+// WithMaxLength setup the max length for publish messages, the stream is
+// trimmed by every XADD. Zero disables the trimming, a negative length is
+// rejected with ErrInvalidMaxLen.
 func WithMaxLength(m int64) Option {
 	return func(w *options) {
 		w.maxLength = m
 	}
 }
 
+// WithMaxLen is an alias of WithMaxLength
+func WithMaxLen(n int64) Option {
+	return WithMaxLength(n)
+}
+
+// WithPollInterval setup the block time used when WithBlockTime is zero,
+// so the read loop regularly checks whether the worker is stopping
+func WithPollInterval(d time.Duration) Option {
+	return func(w *options) {
+		w.pollInterval = d
+	}
+}
+
+// WithRetryBackoff setup the exponential backoff between consecutive
+// failed reads, it starts at base, doubles up to max and is reset by
+// the first successful read
+func WithRetryBackoff(base, maxDelay time.Duration) Option {
+	return func(w *options) {
+		w.retryBase = base
+		w.retryMax = maxDelay
+	}
+}
+
+// WithBackoffJitter toggles the full jitter of the retry backoffs, each
+// delay is picked at random between zero and the exponential delay so
+// the workers reconnecting after an outage spread their attempts. It's
+// enabled by default.
+func WithBackoffJitter(enabled bool) Option {
+	return func(w *options) {
+		w.backoffJitter = enabled
+	}
+}
+
+// WithReadCount setup the max number of entries fetched by a single
+// XREADGROUP call, a bigger count reduces round trips while draining a backlog.
+// A count below 1 is rejected with ErrInvalidReadCount.
+func WithReadCount(n int) Option {
+	return func(w *options) {
+		w.readCount = n
+	}
+}
+
+// WithMaxLenApprox setup the max length for publish messages using the
+// approximate trimming "MAXLEN ~", cheaper than the exact one on busy streams
+func WithMaxLenApprox(m int64) Option {
+	return func(w *options) {
+		w.maxLength = m
+		w.approx = true
+	}
+}
+
+// WithMinID trims the entries with an ID lower than id when publishing
+// messages, it can't be used along with a max length
+func WithMinID(id string) Option {
+	return func(w *options) {
+		w.minID = id
+	}
+}
+
+// WithPeriodicTrim trims the streams on every interval with the given
+// strategy, so a stream which stops receiving messages shrinks as well.
+// The trimmer starts along with the consumer. A zero strategy is rejected
+// with ErrInvalidTrimStrategy, it would empty the streams.
+func WithPeriodicTrim(interval time.Duration, strategy TrimStrategy) Option {
+	return func(w *options) {
+		w.trimInterval = interval
+		w.trimStrategy = strategy
+	}
+}
+
 // WithBlockTime setup the block time for publish messages
 // we use the block command to make sure if no entry is found we wait
 // until an entry is found
+// A long block time saves round trips on idle streams but delays the
+// shutdown of a worker using an injected client, zero uses the poll
+// interval instead of blocking forever, see WithPollInterval
 func WithBlockTime(m time.Duration) Option {
 	return func(w *options) {
 		w.blockTime = m
 	}
 }
 
-// WithPassword redis password
+// WithDB selects the redis database index for a single client built
+// from WithAddr. It must not be negative. Cluster mode ignores it since
+// Redis Cluster only supports database 0, and a connection string picks
+// the database from its URL.
 func WithDB(db int) Option {
 	return func(w *options) {
 		w.db = db
 	}
 }
 
-// WithCluster redis cluster
+// WithTracerProvider traces the processing of each message with a
+// redisdb.process span, linked to the trace context of the producer when
+// the message carries a traceparent field, e.g. set with QueueWithFields.
+// Default is a no-op tracer.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(w *options) {
+		if tp == nil {
+			return
+		}
+		w.tracerProvider = tp
+	}
+}
+
+// WithAckRetry retries a failed XACK up to attempts times in total, with
+// the backoff of WithRetryBackoff, when the error is transient. The IDs
+// which fail every attempt are acked again before the next read instead
+// of staying pending.
+func WithAckRetry(attempts int) Option {
+	return func(w *options) {
+		w.ackAttempts = attempts
+	}
+}
+
+// WithPoolSize sets the max number of connections of the client built by
+// the worker, zero keeps the go-redis default.
+func WithPoolSize(n int) Option {
+	return func(w *options) {
+		w.poolSize = n
+	}
+}
+
+// WithMinIdleConns sets the min number of idle connections kept open by
+// the client built by the worker.
+func WithMinIdleConns(n int) Option {
+	return func(w *options) {
+		w.minIdleConns = n
+	}
+}
+
+// WithConnMaxLifetime closes the connections of the client built by the
+// worker once they reach the given age, zero keeps them open.
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(w *options) {
+		w.connMaxLifetime = d
+	}
+}
+
+// WithCluster redis cluster, it's implied when WithAddr holds several
+// comma separated addresses
 func WithCluster() Option {
 	return func(w *options) {
 		w.cluster = true
 	}
 }
 
+// WithSentinel connects through redis sentinel to the given master,
+// the failover client follows the master when sentinel promotes a replica
+func WithSentinel(masterName string, sentinelAddrs []string) Option {
+	return func(w *options) {
+		w.masterName = masterName
+		w.sentinelAddrs = sentinelAddrs
+	}
+}
+
+// WithSentinelPassword password used to authenticate with the sentinel
+// nodes, it can differ from the password of the redis master
+func WithSentinelPassword(passwd string) Option {
+	return func(w *options) {
+		w.sentinelPassword = passwd
+	}
+}
+
 // WithStreamName Stream name
 func WithStreamName(name string) Option {
 	return func(w *options) {
@@ -73,6 +387,64 @@ func WithStreamName(name string) Option {
 	}
 }
 
+// WithPayloadField setup the stream field holding the task payload,
+// default is "body"
+func WithPayloadField(name string) Option {
+	return func(w *options) {
+		w.payloadField = name
+	}
+}
+
+// WithStartID setup the ID from which a new consumer group starts reading,
+// "$" (default) only reads new messages, "0" reads the whole stream, or
+// any stream ID to resume from a known point
+func WithStartID(id string) Option {
+	return func(w *options) {
+		w.startID = id
+	}
+}
+
+// StartFromTime makes a new consumer group start reading from the first
+// message added at t, e.g. time.Now().Add(-10*time.Minute) to replay the
+// last ten minutes. A time in the future is rejected with
+// ErrInvalidStartID.
+func StartFromTime(t time.Time) Option {
+	return func(w *options) {
+		w.startID = idFromTime(t)
+		w.startTime = t
+	}
+}
+
+// WithStreams reads from several streams with the same consumer group,
+// Queue publishes to the first one
+func WithStreams(names ...string) Option {
+	return func(w *options) {
+		w.streams = names
+		if len(names) > 0 {
+			w.streamName = names[0]
+		}
+	}
+}
+
+// WithPriorityStreams reads from several streams like WithStreams but in
+// priority order, highest first: a stream is only read once all the
+// streams before it are empty. Queue publishes to the first one.
+func WithPriorityStreams(names []string) Option {
+	return func(w *options) {
+		WithStreams(names...)(w)
+		w.priority = true
+	}
+}
+
+// WithPriorityFairness starts every n-th read of WithPriorityStreams from
+// the lowest priority stream so a steady flow of higher priority messages
+// doesn't starve it, zero always honors the priority order.
+func WithPriorityFairness(every int) Option {
+	return func(w *options) {
+		w.priorityFairness = every
+	}
+}
+
 // WithGroup group name
 func WithGroup(name string) Option {
 	return func(w *options) {
@@ -87,6 +459,48 @@ func WithConsumer(name string) Option {
 	}
 }
 
+// WithAutoConsumerName generates a unique consumer name made of the
+// prefix, the hostname, the pid and a random suffix when WithConsumer
+// isn't set, so replicas don't share a pending entries list by mistake.
+func WithAutoConsumerName(prefix string) Option {
+	return func(w *options) {
+		w.autoConsumerPrefix = prefix
+	}
+}
+
+// autoConsumerName returns "<prefix>-<hostname>-<pid>-<rand>"
+func autoConsumerName(prefix string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		binary.BigEndian.PutUint32(suffix, uint32(time.Now().UnixNano()))
+	}
+
+	return fmt.Sprintf("%s-%s-%d-%s", prefix, hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// WithConcurrency starts n read loops feeding the worker, each one reads
+// with its own consumer name "<consumer>-<index>" in the same group.
+// Default is 1, a value below 1 is rejected with ErrInvalidConcurrency.
+func WithConcurrency(n int) Option {
+	return func(w *options) {
+		w.concurrency = n
+	}
+}
+
+// WithDeleteConsumerOnShutdown removes the consumer from the group on
+// shutdown, e.g. when scaling down for good. Its pending messages are
+// orphaned and only reachable through XCLAIM from another consumer.
+func WithDeleteConsumerOnShutdown(enable bool) Option {
+	return func(w *options) {
+		w.deleteConsumerOnShutdown = enable
+	}
+}
+
 // WithUsername redis username
 // This is only used for redis cluster
 func WithUsername(username string) Option {
@@ -102,6 +516,18 @@ func WithPassword(passwd string) Option {
 	}
 }
 
+// WithCredentialsProvider fetches the username and password each time a
+// new connection is opened, so rotated ACL credentials are picked up
+// without recreating the worker. It overrides WithUsername/WithPassword.
+// It relies on the CredentialsProvider field of go-redis (v9.0.5+),
+// which the sentinel failover client doesn't support yet, the combination
+// with WithSentinel is rejected with ErrSentinelCredentials.
+func WithCredentialsProvider(fn func() (username string, password string)) Option {
+	return func(w *options) {
+		w.credentialsProvider = fn
+	}
+}
+
 // WithConnectionString redis connection string
 func WithConnectionString(connectionString string) Option {
 	return func(w *options) {
@@ -109,6 +535,71 @@ func WithConnectionString(connectionString string) Option {
 	}
 }
 
+// WithConnectTimeout bounds the startup ping, so the worker fails fast
+// when redis is unreachable instead of waiting for the dial timeout
+func WithConnectTimeout(d time.Duration) Option {
+	return func(w *options) {
+		w.connectTimeout = d
+	}
+}
+
+// WithSkipPing skips the startup ping so NewWorker doesn't block on the
+// connection, e.g. with lazy connections or a client injected with
+// WithClient which was already checked. A connection error then only
+// surfaces on the first redis call, while consuming or publishing.
+func WithSkipPing(enabled bool) Option {
+	return func(w *options) {
+		w.skipPing = enabled
+	}
+}
+
+// WithShutdownTimeout setup how long Shutdown waits for the background
+// goroutines and the in-flight tasks before closing the connection
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(w *options) {
+		w.shutdownTimeout = d
+	}
+}
+
+// WithRequestTimeout setup how long Request waits for a task before
+// returning queue.ErrNoTaskInQueue, zero waits until a task arrives
+// or the worker is shut down
+func WithRequestTimeout(d time.Duration) Option {
+	return func(w *options) {
+		w.requestTimeout = d
+	}
+}
+
+// WithCodec set a custom codec to encode the tasks, default is JSONCodec.
+// Producers and consumers of a stream must use the same codec.
+func WithCodec(c Codec) Option {
+	return func(w *options) {
+		if c == nil {
+			return
+		}
+		w.codec = c
+	}
+}
+
+// WithUnmarshalFunc builds the tasks returned by Request from the payload
+// of the messages instead of decoding them with the codec, e.g. to read
+// the envelope of producers written in another language. It returns a
+// job.Message since golang-queue drops any other task type.
+func WithUnmarshalFunc(fn func([]byte) (*job.Message, error)) Option {
+	return func(w *options) {
+		w.unmarshalFunc = fn
+	}
+}
+
+// WithProcessTimeout bounds the processing of each task, its context is
+// cancelled once d expires and Run returns ErrProcessTimeout, so the
+// message is handled like any other failure
+func WithProcessTimeout(d time.Duration) Option {
+	return func(w *options) {
+		w.processTimeout = d
+	}
+}
+
 // WithRunFunc setup the run func of queue
 func WithRunFunc(fn func(context.Context, core.TaskMessage) error) Option {
 	return func(w *options) {
@@ -116,13 +607,118 @@ func WithRunFunc(fn func(context.Context, core.TaskMessage) error) Option {
 	}
 }
 
-// WithLogger set custom logger
+// WithPanicRecovery turns a panic of the run func into an error returned
+// by Run instead of crashing the consumer, it's enabled by default
+func WithPanicRecovery(enable bool) Option {
+	return func(w *options) {
+		w.panicRecovery = enable
+	}
+}
+
+// WithContext setup the parent context of the redis calls made by the
+// worker, cancelling it stops the consumption once the pending read returns
+func WithContext(ctx context.Context) Option {
+	return func(w *options) {
+		w.ctx = ctx
+	}
+}
+
+// WithLogger set custom logger, any golang-queue logger such as
+// queue.NewEmptyLogger() to silence the worker. A nil logger keeps the
+// default stdlib-backed queue.NewLogger().
 func WithLogger(l queue.Logger) Option {
 	return func(w *options) {
+		if l == nil {
+			return
+		}
 		w.logger = l
 	}
 }
 
+// WithAckOnSuccess defers the XACK of a message until Run returns nil.
+// A message whose processing fails is left in the pending entries list
+// so it can be inspected with XPENDING and reclaimed later.
+func WithAckOnSuccess(enable bool) Option {
+	return func(w *options) {
+		w.ackOnSuccess = enable
+	}
+}
+
+// WithNoAck reads with NOACK so messages never enter the pending entries
+// list and are never acked. This is at-most-once delivery: a message is
+// lost if the worker stops or fails before processing it, and the
+// re-queue on shutdown, the pending recovery and the auto claim are disabled.
+func WithNoAck(enable bool) Option {
+	return func(w *options) {
+		w.noAck = enable
+	}
+}
+
+// WithoutConsumerGroup tails the streams with XREAD from the start ID
+// instead of reading them with a consumer group, e.g. to fan every
+// message out to every worker. The position is only tracked in memory
+// and nothing is acked, so there is no at-least-once guarantee: like
+// WithNoAck a message is lost if the worker stops before processing it.
+// A single read loop is used whatever WithConcurrency.
+func WithoutConsumerGroup(enable bool) Option {
+	return func(w *options) {
+		w.noGroup = enable
+	}
+}
+
+// WithRecoverPending re-delivers the messages left in the pending entries
+// list of this consumer before reading new ones from the stream.
+func WithRecoverPending(enable bool) Option {
+	return func(w *options) {
+		w.recoverPending = enable
+	}
+}
+
+// WithRecoverPendingCount setup the max number of pending entries
+// scanned on startup by WithRecoverPending
+func WithRecoverPendingCount(n int64) Option {
+	return func(w *options) {
+		w.recoverPendingCount = n
+	}
+}
+
+// WithAutoClaim periodically claims the messages which stayed idle for
+// longer than minIdle in the pending entries list of other consumers,
+// e.g. because the consumer died before acking them.
+func WithAutoClaim(interval, minIdle time.Duration) Option {
+	return func(w *options) {
+		w.autoClaimInterval = interval
+		w.autoClaimMinIdle = minIdle
+	}
+}
+
+// WithMaxRetries setup how many times a failed message is delivered again
+// before it is moved to the dead-letter stream, zero disables dead-lettering.
+// Redeliveries happen through WithRecoverPending and WithAutoClaim.
+func WithMaxRetries(n int) Option {
+	return func(w *options) {
+		w.maxRetries = n
+	}
+}
+
+// WithDeadLetterStream setup the stream receiving the messages which
+// exceeded the max retries, default is "<stream name>:dead-letter"
+func WithDeadLetterStream(name string) Option {
+	return func(w *options) {
+		w.deadLetterStream = name
+	}
+}
+
+// WithMetrics set custom metrics receiving the processing events
+func WithMetrics(m Metrics) Option {
+	return func(w *options) {
+		if m == nil {
+			return
+		}
+		w.metrics = m
+	}
+}
+
 // WithTLS returns an Option that configures the use of TLS for the connection.
 // It sets the minimum TLS version to TLS 1.2.
 func WithTLS() Option {
@@ -151,16 +747,463 @@ func WithSkipTLSVerify() Option {
 	}
 }
 
+// validate checks the options which can't be enforced by the setters
+func (o options) validate() error {
+	if o.tlsErr != nil {
+		return o.tlsErr
+	}
+	if o.startID != "$" && !isStreamID(o.startID) {
+		return fmt.Errorf("%w: %q", ErrInvalidStartID, o.startID)
+	}
+	if o.startTime.After(time.Now()) {
+		return fmt.Errorf("%w: %s is in the future", ErrInvalidStartID, o.startTime)
+	}
+	if o.maxLength < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidMaxLen, o.maxLength)
+	}
+	if o.minID != "" {
+		if o.maxLength > 0 {
+			return ErrTrimConflict
+		}
+		if !isStreamID(o.minID) {
+			return fmt.Errorf("%w: %q", ErrInvalidMinID, o.minID)
+		}
+	}
+	if o.trimInterval < 0 {
+		return fmt.Errorf("%w: interval %s", ErrInvalidTrimStrategy, o.trimInterval)
+	}
+	if o.trimInterval > 0 {
+		if err := o.trimStrategy.validate(); err != nil {
+			return err
+		}
+	}
+	if o.retryBase <= 0 || o.retryMax < o.retryBase {
+		return fmt.Errorf("%w: base %s, max %s", ErrInvalidBackoff, o.retryBase, o.retryMax)
+	}
+	if o.dedupTTL < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidDedupTTL, o.dedupTTL)
+	}
+	if o.adaptiveBatchMax > 0 && (o.adaptiveBatchMin <= 0 || o.adaptiveBatchMin > o.adaptiveBatchMax) {
+		return fmt.Errorf("%w: min %d, max %d", ErrInvalidAdaptiveBatch, o.adaptiveBatchMin, o.adaptiveBatchMax)
+	}
+	if o.publishAttempts > 1 && o.publishBackoff <= 0 {
+		return fmt.Errorf("%w: publish backoff %s", ErrInvalidBackoff, o.publishBackoff)
+	}
+	if o.readCount <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidReadCount, o.readCount)
+	}
+	if o.concurrency <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidConcurrency, o.concurrency)
+	}
+	if o.ackBatchSize <= 0 || o.ackFlushInterval < 0 {
+		return fmt.Errorf("%w: size %d, flush interval %s", ErrInvalidAckBatch, o.ackBatchSize, o.ackFlushInterval)
+	}
+	if o.rateLimit < 0 || math.IsNaN(o.rateLimit) || math.IsInf(o.rateLimit, 0) {
+		return fmt.Errorf("%w: %v", ErrInvalidRateLimit, o.rateLimit)
+	}
+	if o.taskBuffer < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidTaskBuffer, o.taskBuffer)
+	}
+	if o.poolSize < 0 || o.minIdleConns < 0 || o.connMaxLifetime < 0 {
+		return fmt.Errorf("%w: pool size %d, min idle conns %d, conn max lifetime %s",
+			ErrInvalidPool, o.poolSize, o.minIdleConns, o.connMaxLifetime)
+	}
+	if o.produceBatchCount < 0 || o.produceBatchBytes < 0 || o.produceBatchInterval < 0 {
+		return fmt.Errorf("%w: count %d, bytes %d, interval %s",
+			ErrInvalidProduceBatch, o.produceBatchCount, o.produceBatchBytes, o.produceBatchInterval)
+	}
+	if o.pelThresholdHandler != nil && (o.pelThreshold <= 0 || o.pelCheckInterval <= 0) {
+		return fmt.Errorf("%w: threshold %d, check interval %s",
+			ErrInvalidPELThreshold, o.pelThreshold, o.pelCheckInterval)
+	}
+	if o.db < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidDB, o.db)
+	}
+	if o.credentialsProvider != nil && o.masterName != "" && o.connectionString == "" {
+		return fmt.Errorf("%w: master %q", ErrSentinelCredentials, o.masterName)
+	}
+	if o.blockTime < 0 || o.pollInterval <= 0 {
+		return fmt.Errorf("%w: block time %s, poll interval %s",
+			ErrInvalidBlockTime, o.blockTime, o.pollInterval)
+	}
+
+	return nil
+}
+
+// WithTLSFromFiles enables TLS with the client certificate and key read
+// from certFile and keyFile, and the CA certificates from caFile to
+// verify the server. Each file is optional, an empty name is skipped.
+// An unreadable or malformed file makes NewWorkerWithError fail.
+func WithTLSFromFiles(certFile, keyFile, caFile string) Option {
+	return func(w *options) {
+		cfg, err := loadTLSConfig(certFile, keyFile, caFile)
+		if err != nil {
+			w.tlsErr = fmt.Errorf("%w: %w", ErrInvalidTLSConfig, err)
+			return
+		}
+		if w.tls != nil {
+			cfg.InsecureSkipVerify = w.tls.InsecureSkipVerify
+		}
+		w.tls = cfg
+	}
+}
+
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// WithInsecureSkipVerify enables TLS and controls whether the server
+// certificate is verified, only skip the verification in development.
+func WithInsecureSkipVerify(skip bool) Option {
+	return func(w *options) {
+		if w.tls == nil {
+			w.tls = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		w.tls.InsecureSkipVerify = skip //nolint: gosec
+	}
+}
+
+// WithDedupKey skips the messages whose value of the given field was
+// already processed, the duplicates are acked without reaching Run.
+// Producers set the field with QueueWithDedupKey or their own XADD.
+// A key is claimed when the message is delivered, released if Run fails
+// or the message is re-queued, and kept for the dedup TTL once Run
+// succeeds.
+func WithDedupKey(field string) Option {
+	return func(w *options) {
+		w.dedupKey = field
+	}
+}
+
+// WithDedupTTL setup how long a processed dedup key is remembered,
+// default is 24 hours and zero keeps the keys forever.
+func WithDedupTTL(d time.Duration) Option {
+	return func(w *options) {
+		w.dedupTTL = d
+	}
+}
+
+// WithStructuredLogger logs the read, ack, requeue and error events with
+// the stream, group, consumer and message_id fields. By default the
+// fields are appended as key=value pairs to the WithLogger messages.
+func WithStructuredLogger(l StructuredLogger) Option {
+	return func(w *options) {
+		w.structuredLogger = l
+	}
+}
+
+// WithDelayedDelivery runs a background goroutine which moves the
+// messages sent with QueueWithDelay to the streams once they are due,
+// it checks every poll interval.
+func WithDelayedDelivery(enabled bool) Option {
+	return func(w *options) {
+		w.delayedDelivery = enabled
+	}
+}
+
+// WithAckBatch buffers the acks and sends them with a single XACK once
+// size IDs are buffered or every flushInterval, the buffer is flushed
+// on Shutdown. A size of 1, the default, acks every message on its own,
+// a size below 1 or a negative interval is rejected with
+// ErrInvalidAckBatch.
+func WithAckBatch(size int, flushInterval time.Duration) Option {
+	return func(w *options) {
+		w.ackBatchSize = size
+		w.ackFlushInterval = flushInterval
+	}
+}
+
+// WithExplicitID computes the stream ID of each queued task instead of
+// letting redis generate it, e.g. "<ms>-<seq>" to enforce the order.
+// Queue returns ErrStaleMessageID when the ID isn't greater than the
+// last entry of the stream. Re-queued and delayed messages still get a
+// generated ID.
+func WithExplicitID(fn func(data interface{}) string) Option {
+	return func(w *options) {
+		w.explicitID = fn
+	}
+}
+
+// WithRecreateGroupOnNoGroup recreates the stream and the consumer group
+// at the start ID when they are deleted while the worker runs, e.g. by
+// XGROUP DESTROY or DEL. Otherwise the read loop stops on NOGROUP.
+func WithRecreateGroupOnNoGroup(enabled bool) Option {
+	return func(w *options) {
+		w.recreateGroup = enabled
+	}
+}
+
+// WithErrorHandler is called with the read, ack, requeue and group
+// creation errors in addition to logging them, e.g. to report them to
+// Sentry. It's called from the worker goroutines and must be safe for
+// concurrent use.
+func WithErrorHandler(fn func(err error, ctx ErrorContext)) Option {
+	return func(w *options) {
+		w.errorHandler = fn
+	}
+}
+
+// WithCompression compresses the payloads with the codec before XADD,
+// e.g. GzipCompression{}. The codec name is stored in the "compression"
+// field, the workers read compressed and plain messages alike.
+func WithCompression(codec CompressionCodec) Option {
+	return func(w *options) {
+		w.compression = codec
+	}
+}
+
+// WithCompressionThreshold skips the compression of the payloads
+// smaller than n bytes.
+func WithCompressionThreshold(n int) Option {
+	return func(w *options) {
+		w.compressionThreshold = n
+	}
+}
+
+// WithNoMkStream creates the consumer group without MKSTREAM, the
+// streams must already exist and NewWorkerWithError returns
+// ErrStreamNotFound otherwise, catching typos in the stream names.
+func WithNoMkStream(enabled bool) Option {
+	return func(w *options) {
+		w.noMkStream = enabled
+	}
+}
+
+// WithLogEmptyReads logs every read which returns no message once the
+// block time elapses, they aren't logged by default.
+func WithLogEmptyReads(enabled bool) Option {
+	return func(w *options) {
+		w.logEmptyReads = enabled
+	}
+}
+
+// WithClaimOwnPending makes each read loop read its own pending entries
+// from ID "0" before the new messages, so a restarted consumer finishes
+// the messages it read but didn't ack before taking new ones.
+func WithClaimOwnPending(enabled bool) Option {
+	return func(w *options) {
+		w.claimOwnPending = enabled
+	}
+}
+
+// WithRateLimit delivers at most perSecond messages per second across
+// the read loops, the worker waits for the limiter before handing over
+// each message. Default is unlimited, a negative or non-finite rate is
+// rejected with ErrInvalidRateLimit.
+func WithRateLimit(perSecond float64) Option {
+	return func(w *options) {
+		w.rateLimit = perSecond
+	}
+}
+
+// WithMaxInFlight pauses the reads once n delivered messages aren't
+// processed yet, and resumes when they drop to n/2. The read count is
+// capped so a batch doesn't overshoot the limit.
+func WithMaxInFlight(n int) Option {
+	return func(w *options) {
+		w.maxInFlight = n
+	}
+}
+
+// WithProduceNoMkStream adds the messages with XADD NOMKSTREAM, queueing
+// to a stream which doesn't exist fails with ErrStreamNotFound instead
+// of creating it.
+func WithProduceNoMkStream(enabled bool) Option {
+	return func(w *options) {
+		w.produceNoMkStream = enabled
+	}
+}
+
+// WithMaxPayloadBytes rejects the payloads larger than n bytes, once
+// encoded and compressed, with ErrPayloadTooLarge instead of adding
+// them to the stream, see WithLargePayloadHandler.
+func WithMaxPayloadBytes(n int) Option {
+	return func(w *options) {
+		w.maxPayloadBytes = n
+	}
+}
+
+// WithLargePayloadHandler stores the payloads above WithMaxPayloadBytes
+// with the handler and only adds their reference to the stream, the
+// workers load them back with the same handler.
+func WithLargePayloadHandler(h LargePayloadHandler) Option {
+	return func(w *options) {
+		w.largePayloadHandler = h
+	}
+}
+
+// WithRequeueDelay delays the messages re-queued by the worker, e.g. on
+// shutdown, with the delayed delivery of QueueWithDelay so a restarted
+// worker doesn't pick them up again right away. It enables the delayed
+// delivery of this worker.
+func WithRequeueDelay(d time.Duration) Option {
+	return func(w *options) {
+		w.requeueDelay = d
+	}
+}
+
+// WithRequeueStream re-queues the messages left unhandled on shutdown to
+// the given stream instead of their source stream, e.g. a recovery stream
+// drained by a dedicated process. With WithRequeueDelay the delayed
+// messages are promoted by the workers reading that stream.
+func WithRequeueStream(name string) Option {
+	return func(w *options) {
+		w.requeueStream = name
+	}
+}
+
+// WithMessageValidator checks the payload of each message before Request
+// returns it, e.g. against a schema. A rejected message is skipped, it's
+// moved to the dead-letter stream with the validation error when
+// WithMaxRetries is set. Otherwise it stays pending with WithAckOnSuccess,
+// while a message acked on delivery is dropped and its payload logged.
+func WithMessageValidator(fn func(raw []byte) error) Option {
+	return func(w *options) {
+		w.messageValidator = fn
+	}
+}
+
+// WithTaskBuffer buffers up to size read messages for Request so the read
+// loops don't wait for each message to be requested, which smooths the
+// throughput when the processing time varies. The buffered messages are
+// re-queued on shutdown. Default is unbuffered.
+func WithTaskBuffer(size int) Option {
+	return func(w *options) {
+		w.taskBuffer = size
+	}
+}
+
+// WithAdaptiveBatch tunes the read count of each read loop between min
+// and max instead of WithReadCount, it doubles after a full batch to
+// drain a backlog and halves after an empty read to keep the latency low.
+func WithAdaptiveBatch(min, max int) Option {
+	return func(w *options) {
+		w.adaptiveBatchMin = min
+		w.adaptiveBatchMax = max
+	}
+}
+
+// WithConsumerFunc resolves the consumer name before each read, e.g. to
+// read with per-shard consumers. The messages of a read are owned by the
+// returned name in the pending entries list, XACK only needs the group
+// so they are acked whatever the name. Beware that every name stays in
+// the group until it's deleted, and that the recovery of the own pending
+// entries and DeleteConsumer only know the static consumer names, rely
+// on WithAutoClaim to recover the messages of the dynamic ones. It's
+// called concurrently by the read loops of WithConcurrency.
+func WithConsumerFunc(fn func() string) Option {
+	return func(w *options) {
+		w.consumerFunc = fn
+	}
+}
+
+// WithPublishRetry makes up to attempts XADD calls when they fail with a
+// transient error, such as a network error or a MOVED redirection during
+// a resharding, waiting an exponential delay starting at backoff between
+// them. Errors such as WRONGTYPE aren't retried.
+func WithPublishRetry(attempts int, backoff time.Duration) Option {
+	return func(w *options) {
+		w.publishAttempts = attempts
+		w.publishBackoff = backoff
+	}
+}
+
+// WithBinaryBody stores the payload as raw bytes in the XADD values and
+// reads it back into a buffer of its own, instead of the zero-copy string
+// conversions which share the memory of the payload. Redis strings are
+// binary safe so the stream entries are the same either way.
+func WithBinaryBody(enabled bool) Option {
+	return func(w *options) {
+		w.binaryBody = enabled
+	}
+}
+
+// WithPELThresholdHandler calls fn from a background goroutine once the
+// number of pending entries of the group, summed over the streams, reaches
+// threshold, e.g. to alert when the consumers fall behind or don't ack.
+// It's called again only after the count went back below the threshold.
+// The count is checked on every WithPELCheckInterval.
+func WithPELThresholdHandler(threshold int, fn func(count int)) Option {
+	return func(w *options) {
+		w.pelThreshold = threshold
+		w.pelThresholdHandler = fn
+	}
+}
+
+// WithPELCheckInterval sets how often WithPELThresholdHandler checks the
+// pending entries, every 30 seconds by default.
+func WithPELCheckInterval(d time.Duration) Option {
+	return func(w *options) {
+		w.pelCheckInterval = d
+	}
+}
+
+// WithProduceBatch sets when the BatchPublisher flushes its buffer: once
+// it holds count messages or bytes of payload, and every interval. Zero
+// disables a threshold, the default flushes every 100 messages.
+func WithProduceBatch(count int, bytes int, interval time.Duration) Option {
+	return func(w *options) {
+		w.produceBatchCount = count
+		w.produceBatchBytes = bytes
+		w.produceBatchInterval = interval
+	}
+}
+
 func newOptions(opts ...Option) options {
 	defaultOpts := options{
-		streamName: "golang-queue",
-		group:      "golang-queue",
-		consumer:   "golang-queue",
-		logger:     queue.NewLogger(),
+		streamName:     "golang-queue",
+		group:          "golang-queue",
+		payloadField:   "body",
+		startID:        "$",
+		logger:         queue.NewLogger(),
+		metrics:        emptyMetrics{},
+		tracerProvider: noop.NewTracerProvider(),
+		codec:          JSONCodec{},
 		runFunc: func(context.Context, core.TaskMessage) error {
 			return nil
 		},
-		blockTime: 60 * time.Second,
+		blockTime:           60 * time.Second,
+		pollInterval:        time.Second,
+		recoverPendingCount: 100,
+		readCount:           1,
+		concurrency:         1,
+		ackBatchSize:        1,
+		shutdownTimeout:     200 * time.Millisecond,
+		requestTimeout:      5 * time.Second,
+		panicRecovery:       true,
+		ctx:                 context.Background(),
+		retryBase:           100 * time.Millisecond,
+		retryMax:            10 * time.Second,
+		backoffJitter:       true,
+		dedupTTL:            24 * time.Hour,
+		produceBatchCount:   defaultProduceBatchCount,
+		pelCheckInterval:    defaultPELCheckInterval,
 	}
 
 	// Loop through each option
@@ -169,5 +1212,28 @@ func newOptions(opts ...Option) options {
 		opt(&defaultOpts)
 	}
 
+	if defaultOpts.consumer == "" {
+		defaultOpts.consumer = "golang-queue"
+		if defaultOpts.autoConsumerPrefix != "" {
+			defaultOpts.consumer = autoConsumerName(defaultOpts.autoConsumerPrefix)
+		}
+	}
+
+	if len(defaultOpts.streams) == 0 {
+		defaultOpts.streams = []string{defaultOpts.streamName}
+	}
+
+	// nothing to ack without a consumer group
+	if defaultOpts.noGroup {
+		defaultOpts.noAck = true
+	}
+
+	if defaultOpts.structuredLogger == nil {
+		defaultOpts.structuredLogger = newFieldLogger(defaultOpts.logger)
+	}
+	defaultOpts.structuredLogger = defaultOpts.structuredLogger.With(
+		"group", defaultOpts.group,
+	)
+
 	return defaultOpts
 }
@@ -0,0 +1,68 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// pauseCheckInterval is how often a paused read loop checks for Resume
+const pauseCheckInterval = 100 * time.Millisecond
+
+// Pause stops reading new messages, the delivered ones are still
+// processed and the connection stays open. A read already blocking on
+// redis completes first.
+func (w *Worker) Pause() {
+	atomic.StoreInt32(&w.paused, 1)
+}
+
+// Resume starts reading new messages again after Pause
+func (w *Worker) Resume() {
+	atomic.StoreInt32(&w.paused, 0)
+}
+
+// Paused reports whether the consumption is paused
+func (w *Worker) Paused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
+}
+
+// Flush stops reading new messages like Pause, waits until the delivered
+// ones are processed and acked and flushes the batched acks, all bounded
+// by ctx. Unlike Close the connection stays open and Resume starts
+// reading again. If ctx expires it returns an error listing the IDs of
+// the messages still in flight.
+func (w *Worker) Flush(ctx context.Context) error {
+	w.Pause()
+
+	if err := w.drain(ctx); err != nil {
+		if ids := w.inFlightIDs(); len(ids) > 0 {
+			return fmt.Errorf("%w: unacked ids %s", err, strings.Join(ids, ", "))
+		}
+		return err
+	}
+
+	if w.batchAcks() {
+		if err := w.flushAcks(ctx); err != nil {
+			return err
+		}
+	}
+	w.retryDeferredAcks(ctx)
+
+	return nil
+}
+
+// inFlightIDs returns the sorted IDs of the requested messages which
+// aren't processed yet
+func (w *Worker) inFlightIDs() []string {
+	var ids []string
+	w.pending.Range(func(_, v interface{}) bool {
+		ids = append(ids, v.(streamMessage).ID)
+		return true
+	})
+	sort.Strings(ids)
+
+	return ids
+}
@@ -0,0 +1,79 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/appleboy/com/bytesconv"
+)
+
+// payloadRefField holds the reference of a payload kept out of the stream
+const payloadRefField = "payload_ref"
+
+// LargePayloadHandler keeps the payloads above the max payload size out
+// of redis, e.g. in an object store, the stream only holds a reference.
+type LargePayloadHandler interface {
+	// Store saves the payload and returns its reference
+	Store(ctx context.Context, payload []byte) (string, error)
+	// Load returns the payload saved under the reference
+	Load(ctx context.Context, ref string) ([]byte, error)
+}
+
+// payloadValues returns the fields holding the payload. A payload above
+// the max size is stored by the large payload handler, or rejected with
+// ErrPayloadTooLarge without one.
+func (w *Worker) payloadValues(ctx context.Context, payload []byte) (map[string]interface{}, error) {
+	if w.opts.maxPayloadBytes <= 0 || len(payload) <= w.opts.maxPayloadBytes {
+		return map[string]interface{}{w.opts.payloadField: w.bodyValue(payload)}, nil
+	}
+
+	if w.opts.largePayloadHandler == nil {
+		return nil, fmt.Errorf("%w: %d bytes, max %d", ErrPayloadTooLarge, len(payload), w.opts.maxPayloadBytes)
+	}
+
+	ref, err := w.opts.largePayloadHandler.Store(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{payloadRefField: ref}, nil
+}
+
+// bodyValue returns the value of the payload field, the payload itself
+// with WithBinaryBody
+func (w *Worker) bodyValue(payload []byte) interface{} {
+	if w.opts.binaryBody {
+		return payload
+	}
+	return bytesconv.BytesToStr(payload)
+}
+
+// bodyBytes returns the payload field of a message. Redis replies strings,
+// with WithBinaryBody they're copied rather than shared with the payload.
+func (w *Worker) bodyBytes(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return v, true
+	case string:
+		if w.opts.binaryBody {
+			return []byte(v), true
+		}
+		return bytesconv.StrToBytes(v), true
+	}
+	return nil, false
+}
+
+// loadPayload returns the payload of a message stored by reference
+func (w *Worker) loadPayload(ctx context.Context, message streamMessage) (string, bool, error) {
+	ref, ok := message.Values[payloadRefField].(string)
+	if !ok || w.opts.largePayloadHandler == nil {
+		return "", false, nil
+	}
+
+	payload, err := w.opts.largePayloadHandler.Load(ctx, ref)
+	if err != nil {
+		return "", true, err
+	}
+
+	return bytesconv.BytesToStr(payload), true, nil
+}
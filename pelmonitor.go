@@ -0,0 +1,64 @@
+package redisdb
+
+import (
+	"context"
+	"time"
+)
+
+// defaultPELCheckInterval is how often the pending entries lists are
+// checked without WithPELCheckInterval
+const defaultPELCheckInterval = 30 * time.Second
+
+// pendingCount returns the number of pending entries of the group in
+// every stream
+func (w *Worker) pendingCount(ctx context.Context) (int, error) {
+	var total int64
+	for _, stream := range w.opts.streams {
+		pending, err := w.rdb.XPending(ctx, stream, w.opts.group).Result()
+		if err != nil {
+			return 0, err
+		}
+		total += pending.Count
+	}
+
+	return int(total), nil
+}
+
+// monitorPEL checks the pending entries lists on every check interval and
+// calls the threshold handler once the pending count reaches the
+// threshold. It's called again only after the count went back below it.
+func (w *Worker) monitorPEL() {
+	ticker := time.NewTicker(w.opts.pelCheckInterval)
+	defer ticker.Stop()
+
+	var above bool
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		above = w.checkPEL(w.ctx, above)
+	}
+}
+
+// checkPEL calls the threshold handler when the pending count crosses the
+// threshold, it reports whether the count is above it.
+func (w *Worker) checkPEL(ctx context.Context, above bool) bool {
+	count, err := w.pendingCount(ctx)
+	if err != nil {
+		w.opts.logger.Errorf("can't check the pending entries: %v", err)
+		return above
+	}
+	if count < w.opts.pelThreshold {
+		return false
+	}
+	if !above {
+		w.opts.pelThresholdHandler(count)
+	}
+
+	return true
+}
@@ -0,0 +1,238 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-queue/queue"
+	"github.com/golang-queue/queue/core"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultProduceBatchCount is the batch size of BatchPublisher without
+// WithProduceBatch
+const defaultProduceBatchCount = 100
+
+// PublishResult is the outcome of a task buffered by a BatchPublisher,
+// it's known once the batch holding the task is flushed.
+type PublishResult struct {
+	done chan struct{}
+	id   string
+	err  error
+}
+
+func newPublishResult() *PublishResult {
+	return &PublishResult{done: make(chan struct{})}
+}
+
+func (r *PublishResult) resolve(id string, err error) {
+	r.id = id
+	r.err = err
+	close(r.done)
+}
+
+// Done is closed once the task is flushed
+func (r *PublishResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait waits until the task is flushed or ctx expires, it returns the ID
+// of the stream entry or the error of its XADD.
+func (r *PublishResult) Wait(ctx context.Context) (string, error) {
+	select {
+	case <-r.done:
+		return r.id, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// publishEntry is a task waiting in the buffer of a BatchPublisher
+type publishEntry struct {
+	id     string
+	values map[string]interface{}
+	result *PublishResult
+}
+
+// BatchPublisher buffers the tasks sent to the main stream and adds them
+// with pipelined XADDs, which is much faster than Queue for high volumes.
+// A batch is flushed once it holds the count or the bytes of
+// WithProduceBatch, on its interval and by Shutdown. The XADDs of a
+// pipeline aren't retried with WithPublishRetry, since a retry could add
+// a task twice.
+type BatchPublisher struct {
+	w *Worker
+
+	mu      sync.Mutex
+	entries []publishEntry
+	bytes   int
+	closed  bool
+
+	// flushMu serializes the flushes so the batches keep their order
+	flushMu sync.Mutex
+	// wg tracks the periodic flush
+	wg sync.WaitGroup
+}
+
+// BatchPublisher returns the batch publisher of the worker, it's created
+// on the first call and shared by the later ones.
+func (w *Worker) BatchPublisher() *BatchPublisher {
+	w.publisherOnce.Do(func() {
+		w.publisher = &BatchPublisher{w: w}
+		if w.opts.produceBatchInterval > 0 {
+			w.publisher.wg.Add(1)
+			go func() {
+				defer w.publisher.wg.Done()
+				w.publisher.periodicFlush()
+			}()
+		}
+	})
+
+	return w.publisher
+}
+
+// Queue buffers the task, the returned result tells the outcome of its
+// XADD once the batch is flushed. It flushes the batch when it's full,
+// the task is then already added when Queue returns.
+func (p *BatchPublisher) Queue(task core.TaskMessage) (*PublishResult, error) {
+	w := p.w
+	values, err := w.values(task)
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	if w.opts.explicitID != nil {
+		id = w.opts.explicitID(task)
+	}
+
+	result := newPublishResult()
+	p.mu.Lock()
+	if p.closed || atomic.LoadInt32(&w.stopFlag) == 1 {
+		p.mu.Unlock()
+		return nil, queue.ErrQueueShutdown
+	}
+	p.entries = append(p.entries, publishEntry{id: id, values: values, result: result})
+	p.bytes += valuesSize(values)
+	full := (w.opts.produceBatchCount > 0 && len(p.entries) >= w.opts.produceBatchCount) ||
+		(w.opts.produceBatchBytes > 0 && p.bytes >= w.opts.produceBatchBytes)
+	p.mu.Unlock()
+
+	if full {
+		// the error is reported by the result
+		_ = p.Flush()
+	}
+
+	return result, nil
+}
+
+// Flush adds the buffered tasks with a single pipeline. It returns the
+// first error, the results tell which tasks failed.
+func (p *BatchPublisher) Flush() error {
+	return p.flush(p.w.ctx)
+}
+
+func (p *BatchPublisher) flush(ctx context.Context) error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	entries := p.take()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	stream := p.w.opts.streamName
+	cmds := make([]*redis.StringCmd, len(entries))
+	_, err := p.w.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, e := range entries {
+			cmds[i] = pipe.XAdd(ctx, p.w.xaddArgs(stream, e.id, e.values))
+		}
+		return nil
+	})
+
+	var failed int
+	for i, e := range entries {
+		id, cmdErr := cmds[i].Result()
+		if cmdErr != nil {
+			cmdErr = xaddError(stream, e.id, cmdErr)
+			failed++
+		}
+		e.result.resolve(id, cmdErr)
+	}
+	if err != nil {
+		return fmt.Errorf("%d of %d messages not published: %w", failed, len(entries), xaddError(stream, "", err))
+	}
+
+	return nil
+}
+
+// take empties the buffer and returns its content
+func (p *BatchPublisher) take() []publishEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := p.entries
+	p.entries = nil
+	p.bytes = 0
+	return entries
+}
+
+// close flushes the buffer and rejects the tasks queued afterwards. The
+// worker is stopped, it waits for the periodic flush to return so the
+// client isn't closed under it.
+func (p *BatchPublisher) close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.wg.Wait()
+
+	return p.flush(ctx)
+}
+
+// closePublisher flushes the batch publisher, if any, on shutdown
+func (w *Worker) closePublisher(ctx context.Context) {
+	// a publisher created from now on starts closed
+	w.publisherOnce.Do(func() {
+		w.publisher = &BatchPublisher{w: w, closed: true}
+	})
+	if err := w.publisher.close(ctx); err != nil {
+		w.opts.logger.Errorf("can't flush published messages: %v", err)
+	}
+}
+
+// periodicFlush flushes the buffer on every produce batch interval
+func (p *BatchPublisher) periodicFlush() {
+	ticker := time.NewTicker(p.w.opts.produceBatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.w.stop:
+			return
+		case <-p.w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.Flush(); err != nil {
+			p.w.opts.logger.Errorf("can't flush published messages: %v", err)
+		}
+	}
+}
+
+// valuesSize returns the size of the string and bytes values of an entry
+func valuesSize(values map[string]interface{}) int {
+	var size int
+	for k, v := range values {
+		size += len(k)
+		switch v := v.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		}
+	}
+	return size
+}
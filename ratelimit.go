@@ -0,0 +1,33 @@
+package redisdb
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding a single token, refilled every
+// interval, it's shared by the read loops.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is when the next token is available
+	next time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// reserve takes the next token and returns how long to wait for it
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+
+	return d
+}
@@ -2,9 +2,9 @@ package redisdb
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,139 +16,827 @@ import (
 
 	"github.com/appleboy/com/bytesconv"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var _ core.Worker = (*Worker)(nil)
 
+// streamMessage is a stream entry along with the stream it was read from
+type streamMessage struct {
+	redis.XMessage
+	stream string
+	// deliveries is the number of previous deliveries of the message
+	deliveries int64
+	// consumer is the consumer the message was delivered to
+	consumer string
+}
+
 // Worker for Redis
 type Worker struct {
 	// redis config
-	rdb       redis.Cmdable
-	tasks     chan redis.XMessage
+	rdb redis.Cmdable
+	// reader runs the blocking reads, a client of its own when the
+	// worker creates them so Shutdown can abort an in-flight read
+	reader    redis.Cmdable
+	tasks     chan streamMessage
 	stopFlag  int32
 	stopOnce  sync.Once
 	startOnce sync.Once
 	stop      chan struct{}
 	exit      chan struct{}
+	wg        sync.WaitGroup
 	opts      options
+	// pending maps a task returned by Request to its stream entry
+	// so Run can ack it once processing succeeds
+	pending sync.Map
+	// inFlight counts the delivered messages which aren't processed yet
+	inFlight int64
+	// ctx is the root of the redis calls, it's cancelled by Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	// acks buffers the acks with WithAckBatch
+	acks ackBuffer
+	// limiter throttles the deliveries with WithRateLimit
+	limiter *rateLimiter
+	// results publishes the outcome of the processed tasks
+	results resultChannel
+	// counters back Stats
+	counters counters
+	// paused is set by Pause to stop reading new messages
+	paused int32
+	// ackDisabled is set by SetAck to leave the messages pending
+	ackDisabled int32
+	// streamCounters are the counters of each stream with WithStreams
+	streamCounters map[string]*streamCounters
+	// closedOnce reports a client closed externally a single time
+	closedOnce sync.Once
+	// priorityReads counts the reads of the priority streams
+	priorityReads int64
+	// deferredAcks holds the IDs which failed every ack attempt
+	deferredAcks ackBuffer
+	// requeued holds the tasks requeued by the run func with Requeue
+	requeued sync.Map
+	// publisher is created by BatchPublisher
+	publisher     *BatchPublisher
+	publisherOnce sync.Once
 }
 
 // NewWorker for struc
+// It calls logger.Fatal if the worker can't be created, see NewWorkerWithError.
 func NewWorker(opts ...Option) *Worker {
-	var err error
+	w, err := newWorker(opts...)
+	if err != nil {
+		w.opts.logger.Fatal(err)
+	}
+
+	return w
+}
+
+// NewWorkerWithError creates the worker and returns an error instead of
+// exiting the process when the connection string is invalid or redis
+// can't be reached.
+func NewWorkerWithError(opts ...Option) (*Worker, error) {
+	w, err := newWorker(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// newWorker always returns the worker so the caller can use its logger
+func newWorker(opts ...Option) (*Worker, error) {
 	w := &Worker{
-		opts:  newOptions(opts...),
-		stop:  make(chan struct{}),
-		exit:  make(chan struct{}),
-		tasks: make(chan redis.XMessage),
+		opts: newOptions(opts...),
+		stop: make(chan struct{}),
+		exit: make(chan struct{}),
 	}
+	w.tasks = make(chan streamMessage, max(w.opts.taskBuffer, 0))
+	w.results.ch = make(chan ProcessResult, resultsBuffer)
+	w.streamCounters = newStreamCounters(w.opts.streams)
 
-	if w.opts.connectionString != "" {
-		options, err := redis.ParseURL(w.opts.connectionString)
+	if err := w.opts.validate(); err != nil {
+		return w, err
+	}
+
+	if w.opts.rateLimit > 0 {
+		w.limiter = newRateLimiter(w.opts.rateLimit)
+	}
+
+	if w.opts.client != nil {
+		w.rdb = w.opts.client
+		w.reader = w.opts.client
+		if w.opts.readerClient != nil {
+			w.reader = w.opts.readerClient
+		}
+	} else {
+		rdb, err := w.opts.newClient()
 		if err != nil {
-			w.opts.logger.Fatal(err)
-		}
-		w.rdb = redis.NewClient(options)
-	} else if w.opts.addr != "" {
-		if w.opts.cluster {
-			w.rdb = redis.NewClusterClient(&redis.ClusterOptions{
-				Addrs:     strings.Split(w.opts.addr, ","),
-				Username:  w.opts.username,
-				Password:  w.opts.password,
-				TLSConfig: w.opts.tls,
-			})
-		} else {
-			options := &redis.Options{
-				Addr:      w.opts.addr,
-				Username:  w.opts.username,
-				Password:  w.opts.password,
-				DB:        w.opts.db,
-				TLSConfig: w.opts.tls,
-			}
-			w.rdb = redis.NewClient(options)
-		}
-	}
-
-	_, err = w.rdb.Ping(context.Background()).Result()
-	if err != nil {
-		w.opts.logger.Fatal(err)
+			return w, err
+		}
+		w.rdb = rdb
+		// the connections are only dialed once the worker reads
+		if w.reader, err = w.opts.newReaderClient(); err != nil {
+			closeClient(w.rdb)
+			return w, err
+		}
 	}
 
-	return w
+	if !w.opts.skipPing {
+		if err := w.ping(); err != nil {
+			w.closeClient()
+			return w, err
+		}
+	}
+
+	if w.opts.noMkStream {
+		if err := w.checkStreams(w.opts.ctx); err != nil {
+			w.closeClient()
+			return w, err
+		}
+	}
+
+	w.ctx, w.cancel = context.WithCancel(w.opts.ctx)
+
+	return w, nil
+}
+
+// newClient creates the redis client described by the options. Besides
+// a connection string, the client is picked like redis.NewUniversalClient:
+// a failover client with WithSentinel, a cluster client with WithCluster
+// or several addresses, a single node client otherwise.
+func (o options) newClient() (redis.UniversalClient, error) {
+	if o.connectionString != "" {
+		options, err := redis.ParseURL(o.connectionString)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConnectionString, err)
+		}
+		options.CredentialsProvider = o.credentialsProvider
+		if o.poolSize > 0 {
+			options.PoolSize = o.poolSize
+		}
+		if o.minIdleConns > 0 {
+			options.MinIdleConns = o.minIdleConns
+		}
+		if o.connMaxLifetime > 0 {
+			options.ConnMaxLifetime = o.connMaxLifetime
+		}
+		return redis.NewClient(options), nil
+	}
+	if o.addr == "" && o.masterName == "" {
+		return nil, ErrMissingAddr
+	}
+
+	universal := o.universalOptions()
+	switch {
+	case universal.MasterName != "":
+		return redis.NewFailoverClient(universal.Failover()), nil
+	case o.cluster || len(universal.Addrs) > 1:
+		options := universal.Cluster()
+		options.CredentialsProvider = o.credentialsProvider
+		return redis.NewClusterClient(options), nil
+	default:
+		options := universal.Simple()
+		options.CredentialsProvider = o.credentialsProvider
+		return redis.NewClient(options), nil
+	}
+}
+
+// newReaderClient creates the client of the blocking reads, its pool holds
+// a connection per read loop rather than the pool of WithPoolSize
+func (o options) newReaderClient() (redis.UniversalClient, error) {
+	reader := o
+	reader.poolSize = max(o.concurrency, 1)
+	reader.minIdleConns = 0
+	return reader.newClient()
+}
+
+// universalOptions maps the options to the go-redis universal options,
+// the credentials provider isn't part of them.
+func (o options) universalOptions() *redis.UniversalOptions {
+	universal := &redis.UniversalOptions{
+		Username:        o.username,
+		Password:        o.password,
+		DB:              o.db,
+		TLSConfig:       o.tls,
+		PoolSize:        o.poolSize,
+		MinIdleConns:    o.minIdleConns,
+		ConnMaxLifetime: o.connMaxLifetime,
+	}
+	if o.masterName != "" {
+		universal.MasterName = o.masterName
+		universal.Addrs = o.sentinelAddrs
+		universal.SentinelPassword = o.sentinelPassword
+	} else {
+		universal.Addrs = strings.Split(o.addr, ",")
+	}
+
+	return universal
+}
+
+// ping checks the connection, bounded by the connect timeout if any
+func (w *Worker) ping() error {
+	ctx := w.opts.ctx
+	if w.opts.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.opts.connectTimeout)
+		defer cancel()
+	}
+
+	if err := w.rdb.Ping(ctx).Err(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w after %s", ErrPing, ErrConnectTimeout, w.opts.connectTimeout)
+		}
+		return fmt.Errorf("%w: %w", ErrPing, err)
+	}
+
+	return nil
 }
 
 func (w *Worker) startConsumer() {
 	w.startOnce.Do(func() {
-		if err := w.rdb.XGroupCreateMkStream(
-			context.Background(),
-			w.opts.streamName,
-			w.opts.group,
-			"$",
-		).Err(); err != nil {
-			if err.Error() == "BUSYGROUP Consumer Group name already exists" {
-				w.opts.logger.Info(err)
-			} else {
-				w.opts.logger.Error(err)
+		consumers := w.consumers()
+		if w.opts.noGroup {
+			// every read loop would see every message
+			consumers = consumers[:1]
+		} else if !w.startGroups(w.ctx) {
+			consumers = nil
+		}
+
+		for _, consumer := range consumers {
+			w.wg.Add(1)
+			go func(consumer string) {
+				defer w.wg.Done()
+				w.fetchTask(consumer)
+			}(consumer)
+		}
+
+		if w.opts.autoClaimInterval > 0 && !w.opts.noAck {
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				w.autoClaim()
+			}()
+		}
+
+		if w.opts.trimInterval > 0 {
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				w.periodicTrim()
+			}()
+		}
+
+		if w.batchAcks() && w.opts.ackFlushInterval > 0 {
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				w.periodicAckFlush()
+			}()
+		}
+
+		if w.opts.pelThresholdHandler != nil && !w.opts.noGroup {
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				w.monitorPEL()
+			}()
+		}
+
+		if w.opts.delayedDelivery || w.opts.requeueDelay > 0 {
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				w.promoteDelayed()
+			}()
+		}
+
+		// exit is closed once every background goroutine has returned
+		go func() {
+			w.wg.Wait()
+			close(w.exit)
+		}()
+	})
+}
+
+// checkStreams returns ErrStreamNotFound if a stream doesn't exist
+func (w *Worker) checkStreams(ctx context.Context) error {
+	for _, stream := range w.opts.streams {
+		n, err := w.rdb.Exists(ctx, stream).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: %s", ErrStreamNotFound, stream)
+		}
+	}
+
+	return nil
+}
+
+// startGroups creates the consumer groups when the consumer starts, the
+// failures are reported to the error handler. It returns false for a
+// misconfiguration the read loops can't recover from, e.g. a stream name
+// pointing to a key which isn't a stream.
+func (w *Worker) startGroups(ctx context.Context) bool {
+	ok := true
+	for _, stream := range w.opts.streams {
+		err := w.createGroup(ctx, stream)
+		if err == nil {
+			continue
+		}
+		w.opts.logger.Error(err)
+		w.handleError(err, ErrorContext{Stage: ErrorStageGroup, Stream: stream})
+		if errors.Is(err, ErrWrongType) {
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// createGroups creates the consumer group of each stream at the start ID,
+// it logs every failure and returns the first one
+func (w *Worker) createGroups(ctx context.Context) error {
+	var firstErr error
+	for _, stream := range w.opts.streams {
+		if err := w.createGroup(ctx, stream); err != nil {
+			w.opts.logger.Error(err)
+			if firstErr == nil {
+				firstErr = err
 			}
 		}
+	}
+
+	return firstErr
+}
+
+// createGroup creates the consumer group of the stream at the start ID,
+// an existing group isn't an error.
+func (w *Worker) createGroup(ctx context.Context, stream string) error {
+	create := w.rdb.XGroupCreateMkStream
+	if w.opts.noMkStream {
+		create = w.rdb.XGroupCreate
+	}
+	err := create(ctx, stream, w.opts.group, w.opts.startID).Err()
+	if err == nil {
+		return nil
+	}
+	if isBusyGroup(err) {
+		w.opts.logger.Info(err)
+		return nil
+	}
+	if isWrongType(err) {
+		return fmt.Errorf("%w: stream %s: %w", ErrWrongType, stream, err)
+	}
+
+	return err
+}
+
+// EnsureGroup creates the streams and their consumer group if they don't
+// exist yet, without consuming. It lets a provisioning job set them up
+// before any consumer starts and is safe to run repeatedly.
+func (w *Worker) EnsureGroup(ctx context.Context) error {
+	for _, stream := range w.opts.streams {
+		if err := w.createGroup(ctx, stream); err != nil {
+			return fmt.Errorf("create group %s on stream %s: %w", w.opts.group, stream, err)
+		}
+	}
+
+	return nil
+}
 
-		go w.fetchTask()
+// isBusyGroup reports whether the group already exists, it only relies
+// on the error code so it doesn't depend on the wording of the message
+func isBusyGroup(err error) bool {
+	return strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
+
+// isNoGroup reports whether the stream or the group was deleted
+func isNoGroup(err error) bool {
+	return strings.HasPrefix(err.Error(), "NOGROUP")
+}
+
+// clientClosed stops the worker once its client was closed externally,
+// e.g. an injected client closed by its owner, since no call can succeed
+// anymore. The error handler is called a single time.
+func (w *Worker) clientClosed(err error) {
+	w.closedOnce.Do(func() {
+		w.opts.logger.Errorf("stop the worker, the redis client is closed: %v", err)
+		w.handleError(err, ErrorContext{Stage: ErrorStageRead})
+		// stop the other read loops and background tasks
+		w.cancel()
 	})
 }
 
-func (w *Worker) fetchTask() {
+// isWrongType reports whether the key of a stream holds another type
+func isWrongType(err error) bool {
+	return strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+// consumers returns the consumer name of each read loop
+func (w *Worker) consumers() []string {
+	if w.opts.concurrency <= 1 {
+		return []string{w.opts.consumer}
+	}
+
+	names := make([]string, w.opts.concurrency)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", w.opts.consumer, i)
+	}
+	return names
+}
+
+// claimConsumer returns the consumer the claimed entries are assigned
+// to, the one of the first read loop, so they end up where the read loop
+// recovers its own pending entries.
+func (w *Worker) claimConsumer() string {
+	return w.readConsumer(w.consumers()[0])
+}
+
+func (w *Worker) fetchTask(consumer string) {
+	ctx := w.ctx
+	if w.opts.recoverPending && !w.opts.noAck && !w.recoverPending(ctx, consumer) {
+		return
+	}
+
+	// BLOCK 0 waits forever, poll instead so the stop check runs regularly
+	block := w.opts.blockTime
+	if block == 0 {
+		block = w.opts.pollInterval
+	}
+
+	retry := &backoff{base: w.opts.retryBase, max: w.opts.retryMax, jitter: w.opts.backoffJitter}
+
+	// a fixed read count unless the adaptive batch is enabled
+	batch := newAdaptiveBatch(int64(w.opts.readCount), int64(w.opts.readCount))
+	if w.opts.adaptiveBatchMax > 0 {
+		batch = newAdaptiveBatch(int64(w.opts.adaptiveBatchMin), int64(w.opts.adaptiveBatchMax))
+	}
+
+	// all the stream names followed by one ID per stream
+	streams := make([]string, 0, 2*len(w.opts.streams))
+	streams = append(streams, w.opts.streams...)
+	for range w.opts.streams {
+		streams = append(streams, ">")
+	}
+	ids := streams[len(w.opts.streams):]
+
+	// without a group the position is tracked locally
+	if w.opts.noGroup {
+		w.tailIDs(ctx, ids)
+	}
+
+	// read the own pending entries from "0" first, then switch to ">"
+	ownPending := w.opts.claimOwnPending && !w.opts.noAck
+	if ownPending {
+		for i := range ids {
+			ids[i] = "0"
+		}
+	}
+
+	log := w.opts.structuredLogger.With("streams", w.opts.streams, "consumer", consumer)
 	for {
 		select {
 		case <-w.stop:
 			return
+		case <-ctx.Done():
+			return
 		default:
 		}
 
-		ctx := context.Background()
-		data, err := w.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
-			Group:    w.opts.group,
-			Consumer: w.opts.consumer,
-			Streams:  []string{w.opts.streamName, ">"},
-			// count is number of entries we want to read from redis
-			Count: 1,
-			// we use the block command to make sure if no entry is found we wait
-			// until an entry is found
-			Block: w.opts.blockTime,
-		}).Result()
+		w.retryDeferredAcks(ctx)
+
+		if w.Paused() {
+			if !w.wait(ctx, pauseCheckInterval) {
+				return
+			}
+			continue
+		}
+
+		count, ok := w.nextReadCount(ctx, batch.size)
+		if !ok {
+			return
+		}
+
+		name := w.readConsumer(consumer)
+		data, err := w.read(ctx, name, streams, count, block)
 		if err != nil {
-			workerInfo := fmt.Sprintf("{streamName: %q, group: %q, consumer: %q}",
-				w.opts.streamName, w.opts.group, w.opts.consumer)
+			if ctx.Err() != nil {
+				return
+			}
 			if errors.Is(err, redis.Nil) {
-				w.opts.logger.Infof("no data while reading from redis stream %s", workerInfo)
-			} else {
-				w.opts.logger.Errorf("error while reading from redis %s %v", workerInfo, err)
+				retry.reset()
+				if batch.shrink() {
+					log.Info("shrink the read batch", "size", batch.size)
+				}
+				// an empty read is expected, only log it on demand
+				if w.opts.logEmptyReads {
+					log.Info("no data while reading from redis stream")
+				}
+				continue
+			}
+			if isNoGroup(err) {
+				if !w.opts.recreateGroup {
+					log.Error("stop reading, the stream or the group was deleted", "error", err)
+					w.handleError(err, ErrorContext{Stage: ErrorStageRead})
+					return
+				}
+				log.Info("recreate the consumer group", "error", err)
+				if err := w.createGroups(ctx); err != nil {
+					// e.g. an ACL or READONLY error, back off like a failed read
+					delay := retry.next()
+					log.Error("can't recreate the consumer group", "error", err, "retry_in", delay)
+					w.handleError(err, ErrorContext{Stage: ErrorStageRead})
+					if !w.wait(ctx, delay) {
+						return
+					}
+				}
+				continue
+			}
+			if errors.Is(err, redis.ErrClosed) {
+				w.clientClosed(err)
+				return
+			}
+			// a misconfiguration, reading again won't succeed
+			if isWrongType(err) {
+				err = fmt.Errorf("%w: %w", ErrWrongType, err)
+				log.Error("stop reading, a stream name points to a non-stream key", "error", err)
+				w.handleError(err, ErrorContext{Stage: ErrorStageRead})
+				return
 			}
 
+			delay := retry.next()
+			log.Error("error while reading from redis stream", "error", err, "retry_in", delay)
+			w.handleError(err, ErrorContext{Stage: ErrorStageRead})
+			if !w.wait(ctx, delay) {
+				return
+			}
 			continue
 		}
+		retry.reset()
 		// we have received the data we should loop it and queue the messages
 		// so that our tasks can start processing
+		var messages []streamMessage
 		for _, result := range data {
 			for _, message := range result.Messages {
-				select {
-				case w.tasks <- message:
-					if err := w.rdb.XAck(ctx, w.opts.streamName, w.opts.group, message.ID).Err(); err != nil {
-						w.opts.logger.Errorf("can't ack message: %s", message.ID)
-					}
-				case <-w.stop:
-					// Todo: re-queue the task
-					w.opts.logger.Info("re-queue the task: ", message.ID)
-					if err := w.queue(message.Values); err != nil {
-						w.opts.logger.Error("error to re-queue the task: ", message.ID)
+				w.countRead(result.Stream)
+				// the own pending entries were delivered before at least once
+				var deliveries int64
+				if ownPending {
+					deliveries = 1
+				}
+				messages = append(messages, streamMessage{message, result.Stream, deliveries, name})
+			}
+			// the next pending or tail read starts after the last entry
+			if (ownPending || w.opts.noGroup) && len(result.Messages) > 0 {
+				for i, stream := range w.opts.streams {
+					if stream == result.Stream {
+						ids[i] = result.Messages[len(result.Messages)-1].ID
 					}
-					close(w.exit)
-					return
 				}
 			}
 		}
+		if len(messages) > 0 {
+			atomic.StoreInt64(&w.counters.lastRead, time.Now().UnixNano())
+		}
+		// a full batch hints at a backlog
+		if int64(len(messages)) >= count && batch.grow() {
+			log.Info("grow the read batch", "size", batch.size)
+		}
+		// the pending entries are drained once a read returns nothing
+		if ownPending && len(messages) == 0 {
+			ownPending = false
+			for i := range ids {
+				ids[i] = ">"
+			}
+			log.Info("own pending entries drained, read the new messages")
+		}
+		for i, message := range messages {
+			if !w.deliver(ctx, message) {
+				// hand back the rest of the batch as well
+				for _, rest := range messages[i+1:] {
+					w.requeue(ctx, rest, true)
+				}
+				return
+			}
+		}
 	}
 }
 
+// read reads the next batch of messages, blocking until one of the
+// streams has new entries. With WithPriorityStreams the streams are
+// checked one by one in priority order first.
+func (w *Worker) read(
+	ctx context.Context, consumer string, streams []string, count int64, block time.Duration,
+) ([]redis.XStream, error) {
+	if w.opts.noGroup {
+		return w.reader.XRead(ctx, &redis.XReadArgs{
+			Streams: streams,
+			Count:   count,
+			Block:   block,
+		}).Result()
+	}
+
+	if w.opts.priority {
+		data, err := w.readPriority(ctx, consumer, streams, count)
+		if !errors.Is(err, redis.Nil) {
+			return data, err
+		}
+	}
+
+	return w.reader.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    w.opts.group,
+		Consumer: consumer,
+		Streams:  streams,
+		// count is number of entries we want to read from redis
+		Count: count,
+		// we use the block command to make sure if no entry is found we wait
+		// until an entry is found
+		Block: block,
+		NoAck: w.opts.noAck,
+	}).Result()
+}
+
+// readPriority reads the streams without blocking, highest priority
+// first, and returns the first batch which isn't empty or redis.Nil.
+// Every n-th read of WithPriorityFairness starts from the lowest priority
+// so it isn't starved by a steady flow of higher priority messages.
+func (w *Worker) readPriority(
+	ctx context.Context, consumer string, streams []string, count int64,
+) ([]redis.XStream, error) {
+	n := len(w.opts.streams)
+	every := int64(w.opts.priorityFairness)
+	reverse := every > 0 && atomic.AddInt64(&w.priorityReads, 1)%every == 0
+
+	for i := 0; i < n; i++ {
+		j := i
+		if reverse {
+			j = n - 1 - i
+		}
+		data, err := w.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    w.opts.group,
+			Consumer: consumer,
+			Streams:  []string{streams[j], streams[n+j]},
+			Count:    count,
+			// a negative block doesn't wait for new entries
+			Block: -1,
+			NoAck: w.opts.noAck,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range data {
+			if len(result.Messages) > 0 {
+				return data, nil
+			}
+		}
+	}
+
+	return nil, redis.Nil
+}
+
+// readConsumer returns the consumer name of the next read, the one of
+// the read loop unless a consumer func is set.
+func (w *Worker) readConsumer(consumer string) string {
+	if w.opts.consumerFunc == nil {
+		return consumer
+	}
+	return w.opts.consumerFunc()
+}
+
+// nextReadCount returns the number of messages of the next read, count
+// capped by the room left under the in-flight limit. Once the limit is reached
+// it waits for the in-flight messages to drop to half of it, it returns
+// false if the worker stops meanwhile.
+func (w *Worker) nextReadCount(ctx context.Context, count int64) (int64, bool) {
+	limit := int64(w.opts.maxInFlight)
+	if limit <= 0 {
+		return count, true
+	}
+
+	n := atomic.LoadInt64(&w.inFlight)
+	if n >= limit {
+		for n > limit/2 {
+			if !w.wait(ctx, 10*time.Millisecond) {
+				return 0, false
+			}
+			n = atomic.LoadInt64(&w.inFlight)
+		}
+	}
+
+	if room := limit - n; room < count {
+		count = room
+	}
+	return count, true
+}
+
+// wait sleeps for d, it returns false if the worker stops meanwhile
+func (w *Worker) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-w.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// deliver pushes the message onto the tasks channel, it returns false
+// once the worker is stopping and the message has been re-queued.
+func (w *Worker) deliver(ctx context.Context, message streamMessage) bool {
+	if w.processed(ctx, message) {
+		w.messageLogger(message).Info("skip the duplicate task")
+		if !w.opts.noAck {
+			if err := w.ack(context.WithoutCancel(ctx), message.stream, message.ID); err != nil {
+				w.messageLogger(message).Error("can't ack message", "error", err)
+			}
+		}
+		return true
+	}
+
+	if w.limiter != nil {
+		if d := w.limiter.reserve(time.Now()); d > 0 && !w.wait(ctx, d) {
+			w.requeue(ctx, message, true)
+			return false
+		}
+	}
+
+	select {
+	case w.tasks <- message:
+		atomic.AddInt64(&w.inFlight, 1)
+		// with ackOnSuccess the ack is deferred until Run succeeds,
+		// the message is delivered so the ack can't be cancelled
+		if !w.opts.ackOnSuccess && !w.opts.noAck && w.acking() {
+			if err := w.ack(context.WithoutCancel(ctx), message.stream, message.ID); err != nil {
+				w.messageLogger(message).Error("can't ack message", "error", err)
+			}
+		}
+		return true
+	case <-w.stop:
+		w.requeue(ctx, message, true)
+		return false
+	}
+}
+
+// requeue adds the message back to its stream so it isn't lost when
+// the worker stops before delivering it, the call outlives the
+// cancellation of ctx by Shutdown. pending tells whether the entry is
+// still in the pending entries list, it's then acked once the copy is
+// added so it isn't delivered twice.
+func (w *Worker) requeue(ctx context.Context, message streamMessage, pending bool) {
+	// at-most-once delivery, the message is dropped
+	log := w.messageLogger(message)
+	if w.opts.noAck {
+		log.Info("drop the task")
+		return
+	}
+
+	log.Info("re-queue the task", "target_stream", w.requeueTarget(message.stream))
+	if err := w.requeueValues(context.WithoutCancel(ctx), message); err != nil {
+		log.Error("error to re-queue the task", "error", err)
+		w.handleError(err, ErrorContext{Stage: ErrorStageRequeue, Stream: message.stream, MessageID: message.ID})
+		return
+	}
+	w.opts.metrics.IncRequeued()
+	atomic.AddInt64(&w.counters.requeued, 1)
+	// the copy carries the same dedup key
+	w.releaseDedup(context.WithoutCancel(ctx), message)
+
+	if pending && w.acking() {
+		if err := w.ack(context.WithoutCancel(ctx), message.stream, message.ID); err != nil {
+			log.Error("can't ack re-queued message", "error", err)
+		}
+	}
+}
+
+// requeueTarget returns the stream receiving the re-queued messages of
+// the given stream.
+func (w *Worker) requeueTarget(stream string) string {
+	if w.opts.requeueStream != "" {
+		return w.opts.requeueStream
+	}
+	return stream
+}
+
+// requeueValues adds the message back to its stream, or to the delayed
+// messages with a requeue delay.
+func (w *Worker) requeueValues(ctx context.Context, message streamMessage) error {
+	stream := w.requeueTarget(message.stream)
+	if w.opts.requeueDelay <= 0 {
+		return w.queue(ctx, stream, message.Values)
+	}
+
+	return w.schedule(ctx, stream, message.Values, w.opts.requeueDelay)
+}
+
 // Shutdown worker
 func (w *Worker) Shutdown() error {
 	if !atomic.CompareAndSwapInt32(&w.stopFlag, 0, 1) {
@@ -156,73 +844,654 @@ func (w *Worker) Shutdown() error {
 	}
 
 	w.stopOnce.Do(func() {
+		// a worker which never started has no read loop to wait for
+		w.startOnce.Do(func() {
+			close(w.exit)
+		})
 		close(w.stop)
+		// abort the blocking redis calls
+		w.cancel()
+		// the cancellation doesn't interrupt a blocking read, closing
+		// its client does
+		if w.ownsReader() {
+			closeClient(w.reader)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), w.opts.shutdownTimeout)
+		defer cancel()
 
 		// wait requeue
 		select {
 		case <-w.exit:
-		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
 		}
 
-		switch v := w.rdb.(type) {
-		case *redis.Client:
-			v.Close()
-		case *redis.ClusterClient:
-			v.Close()
+		// the buffered tasks won't be requested anymore
+		w.requeueBuffered(ctx)
+
+		// let the in-flight tasks finish before closing the client
+		if err := w.drain(ctx); err != nil {
+			w.opts.logger.Error(err)
+			// golang-queue cancels the tasks once the client is closed
+			w.requeueInFlight(context.WithoutCancel(w.ctx))
 		}
-		close(w.tasks)
+
+		if w.batchAcks() {
+			if err := w.flushAcks(context.WithoutCancel(w.ctx)); err != nil {
+				w.opts.logger.Errorf("can't flush acks: %v", err)
+			}
+		}
+		w.retryDeferredAcks(context.WithoutCancel(w.ctx))
+		w.closePublisher(context.WithoutCancel(w.ctx))
+
+		if w.opts.deleteConsumerOnShutdown && !w.opts.noGroup {
+			n, err := w.DeleteConsumer(context.WithoutCancel(w.ctx))
+			if err != nil {
+				w.opts.logger.Errorf("can't delete consumer: %v", err)
+			} else if n > 0 {
+				w.opts.logger.Infof("delete consumer with %d pending messages", n)
+			}
+		}
+
+		w.closeClient()
+		// a read loop still running may send a task until it exits
+		select {
+		case <-w.exit:
+			close(w.tasks)
+		default:
+			go func() {
+				<-w.exit
+				close(w.tasks)
+			}()
+		}
+		w.results.close()
 	})
 	return nil
 }
 
-func (w *Worker) queue(data interface{}) error {
-	ctx := context.Background()
+// requeueInFlight re-queues the messages acked on delivery which are still
+// processed once the drain timed out, their interruption can't re-queue
+// them after the client is closed. Run leaves them alone afterwards like
+// the tasks requeued by the run func.
+func (w *Worker) requeueInFlight(ctx context.Context) {
+	if w.opts.ackOnSuccess {
+		return
+	}
 
+	w.pending.Range(func(task, v interface{}) bool {
+		if _, loaded := w.requeued.LoadOrStore(task, struct{}{}); !loaded {
+			w.requeue(ctx, v.(streamMessage), false)
+		}
+		return true
+	})
+}
+
+// requeueBuffered re-queues the tasks left in the buffer of WithTaskBuffer
+func (w *Worker) requeueBuffered(ctx context.Context) {
+	for {
+		select {
+		case message := <-w.tasks:
+			atomic.AddInt64(&w.inFlight, -1)
+			// with ackOnSuccess the entry is still pending
+			w.requeue(ctx, message, w.opts.ackOnSuccess)
+		default:
+			return
+		}
+	}
+}
+
+// Close stops reading new messages, waits until the delivered ones are
+// processed or ctx expires, then shuts the worker down. Unlike Shutdown
+// it doesn't give up on the in-flight tasks after the shutdown timeout,
+// it returns an error with the number of tasks left if ctx expires.
+func (w *Worker) Close(ctx context.Context) error {
+	w.Pause()
+
+	err := w.drain(ctx)
+	if shutdownErr := w.Shutdown(); shutdownErr != nil {
+		return shutdownErr
+	}
+
+	return err
+}
+
+// drain waits until every delivered message has been processed
+func (w *Worker) drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		n := atomic.LoadInt64(&w.inFlight)
+		if n <= 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d tasks still in flight: %w", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeClient closes the client created by the worker, an injected
+// client is owned by the caller and stays open.
+func (w *Worker) closeClient() {
+	if w.ownsReader() {
+		closeClient(w.reader)
+	}
+	if w.opts.client == nil {
+		closeClient(w.rdb)
+	}
+}
+
+// ownsReader reports whether the reader is a client of the worker
+func (w *Worker) ownsReader() bool {
+	return w.opts.client == nil || w.opts.readerClient != nil
+}
+
+// closeClient closes the clients created by newClient
+func closeClient(rdb redis.Cmdable) {
+	if v, ok := rdb.(redis.UniversalClient); ok {
+		v.Close()
+	}
+}
+
+func (w *Worker) queue(ctx context.Context, stream string, data interface{}) error {
+	_, err := w.queueWithID(ctx, stream, "", data)
+	return err
+}
+
+// queueWithID publishes the message with the given ID, an empty ID
+// lets redis generate it. It returns the ID of the message.
+func (w *Worker) queueWithID(ctx context.Context, stream, id string, data interface{}) (string, error) {
 	// Publish a message.
-	err := w.rdb.XAdd(ctx, &redis.XAddArgs{
-		Stream: w.opts.streamName,
-		MaxLen: w.opts.maxLength,
-		Values: data,
-	}).Err()
+	var added string
+	err := w.withPublishRetry(ctx, func() error {
+		var err error
+		added, err = w.rdb.XAdd(ctx, w.xaddArgs(stream, id, data)).Result()
+		return err
+	})
+	if err != nil {
+		return "", xaddError(stream, id, err)
+	}
+
+	return added, nil
+}
+
+// xaddArgs returns the XADD arguments with the trimming options
+func (w *Worker) xaddArgs(stream, id string, data interface{}) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream:     stream,
+		NoMkStream: w.opts.produceNoMkStream,
+		MaxLen:     w.opts.maxLength,
+		MinID:      w.opts.minID,
+		Approx:     w.opts.approx,
+		ID:         id,
+		Values:     data,
+	}
+}
+
+// xaddError maps the errors of XADD to the sentinel errors
+func xaddError(stream, id string, err error) error {
+	// with NOMKSTREAM redis replies nil when the stream doesn't exist
+	if errors.Is(err, redis.Nil) {
+		return fmt.Errorf("%w: %s", ErrStreamNotFound, stream)
+	}
+	if strings.Contains(err.Error(), "equal or smaller than the target stream top item") {
+		return fmt.Errorf("%w: %s: %w", ErrStaleMessageID, id, err)
+	}
 
 	return err
 }
 
+// Publish adds the raw payload to the stream and returns the ID of the
+// message, without going through the queue framework. The payload is
+// stored in the payload field with the trimming options of Queue, the
+// workers decode it with the codec so it must be an encoded job.Message.
+func (w *Worker) Publish(ctx context.Context, payload []byte) (string, error) {
+	if atomic.LoadInt32(&w.stopFlag) == 1 {
+		return "", queue.ErrQueueShutdown
+	}
+
+	values, err := w.payloadValues(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+
+	return w.queueWithID(ctx, w.opts.streamName, "", values)
+}
+
 // Queue send notification to queue
 func (w *Worker) Queue(task core.TaskMessage) error {
+	_, err := w.QueueWithID(task)
+	return err
+}
+
+// QueueWithID sends the task like Queue and returns the ID generated
+// by XADD, e.g. to correlate the task with its stream entry.
+func (w *Worker) QueueWithID(task core.TaskMessage) (string, error) {
+	return w.queueTask(task, nil)
+}
+
+// QueueWithDedupKey sends the task with the given dedup key in the
+// field set by WithDedupKey, consumers skip it once a task with the
+// same key was processed.
+func (w *Worker) QueueWithDedupKey(task core.TaskMessage, key string) error {
+	var extra map[string]interface{}
+	if w.opts.dedupKey != "" && key != "" {
+		extra = map[string]interface{}{w.opts.dedupKey: key}
+	}
+
+	return w.QueueWithFields(task, extra)
+}
+
+// QueueWithFields sends the task along with extra stream fields, e.g. a
+// trace or tenant ID, which can be queried without decoding the body.
+// The run func reads them with FieldsFromContext. The payload field and
+// the fields used by the worker are rejected with ErrReservedField.
+func (w *Worker) QueueWithFields(task core.TaskMessage, extra map[string]interface{}) error {
+	_, err := w.queueTask(task, extra)
+	return err
+}
+
+// queueTask adds the task with the extra fields to the main stream and
+// returns the ID of the message.
+func (w *Worker) queueTask(task core.TaskMessage, extra map[string]interface{}) (string, error) {
 	if atomic.LoadInt32(&w.stopFlag) == 1 {
-		return queue.ErrQueueShutdown
+		return "", queue.ErrQueueShutdown
+	}
+
+	values, err := w.values(task)
+	if err != nil {
+		return "", err
+	}
+
+	for k, v := range extra {
+		if k == w.opts.payloadField || k == compressionField || k == payloadRefField || k == attemptsField {
+			return "", fmt.Errorf("%w: %q", ErrReservedField, k)
+		}
+		values[k] = v
+	}
+
+	var id string
+	if w.opts.explicitID != nil {
+		id = w.opts.explicitID(task)
 	}
 
-	return w.queue(map[string]interface{}{"body": bytesconv.BytesToStr(task.Bytes())})
+	return w.queueWithID(w.ctx, w.opts.streamName, id, values)
+}
+
+// values returns the fields of the stream entry holding the task
+func (w *Worker) values(task core.TaskMessage) (map[string]interface{}, error) {
+	body, err := w.encode(task)
+	if err != nil {
+		return nil, err
+	}
+
+	body, compression, err := w.compress(body)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := w.payloadValues(w.ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	if compression != "" {
+		values[compressionField] = compression
+	}
+
+	return values, nil
+}
+
+// encode encodes a job with the codec, any other task is stored as is
+func (w *Worker) encode(task core.TaskMessage) ([]byte, error) {
+	m, ok := task.(*job.Message)
+	if !ok {
+		return task.Bytes(), nil
+	}
+
+	return w.opts.codec.Marshal(m)
+}
+
+// SetAck toggles the acks of the delivered and processed messages at
+// runtime. While disabled the messages stay in the pending entries list,
+// where they can be inspected or acked manually, and are delivered again
+// by WithRecoverPending or WithAutoClaim, so they may be processed twice.
+// Enabling it again acks the messages handled from then on.
+func (w *Worker) SetAck(enabled bool) {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+	atomic.StoreInt32(&w.ackDisabled, disabled)
+}
+
+// acking reports whether the messages are acked, see SetAck
+func (w *Worker) acking() bool {
+	return atomic.LoadInt32(&w.ackDisabled) == 0
+}
+
+func (w *Worker) ack(ctx context.Context, stream, id string) error {
+	if w.batchAcks() {
+		if w.acks.add(stream, id, w.opts.ackBatchSize) {
+			return w.flushAcks(ctx)
+		}
+		return nil
+	}
+
+	if err := w.xack(ctx, stream, id); err != nil {
+		w.handleError(err, ErrorContext{Stage: ErrorStageAck, Stream: stream, MessageID: id})
+		return err
+	}
+	w.countAcks(stream, 1)
+	return nil
 }
 
 // Run start the worker
 func (w *Worker) Run(ctx context.Context, task core.TaskMessage) error {
+	// the mapping is dropped after the last attempt whatever the outcome,
+	// a failed entry stays in the pending entries list and can be
+	// reclaimed later
+	v, ok := w.pending.Load(task)
+	var retry bool
+	defer func() {
+		if !retry {
+			w.done(task)
+		}
+	}()
+	message, _ := v.(streamMessage)
+
+	var span trace.Span
+	if ok {
+		ctx = context.WithValue(ctx, fieldsKey{}, w.fields(message))
+		ctx = context.WithValue(ctx, deliveryKey{}, int(message.deliveries)+1)
+		ctx = context.WithValue(ctx, attemptsKey{}, attempts(message))
+		if t := EnqueuedAt(message.ID); !t.IsZero() {
+			ctx = context.WithValue(ctx, enqueuedAtKey{}, t)
+		}
+		ctx, span = w.startSpan(ctx, message)
+	}
+
+	start := time.Now()
+	err := w.runTask(ctx, task)
+	elapsed := time.Since(start)
+	endSpan(span, err)
+	w.opts.metrics.ObserveLatency(elapsed)
+	w.results.send(ProcessResult{
+		MessageID: message.ID,
+		Stream:    message.stream,
+		Success:   err == nil,
+		Err:       err,
+		Duration:  elapsed,
+	})
+	// the original entry was acked by Requeue or re-queued by Shutdown
+	if _, requeued := w.requeued.LoadAndDelete(task); requeued {
+		return err
+	}
+	// golang-queue runs the task again, the next attempt settles it
+	if ok && willRetry(ctx, task, err) {
+		retry = true
+		// the queue gives up on the retries once ctx is done
+		context.AfterFunc(ctx, func() {
+			w.done(task)
+		})
+		return err
+	}
+	// cancelled by the queue shutting down, the message didn't fail and
+	// must be processed again. An expired deadline, e.g. the timeout of
+	// the job, is a failure.
+	if err != nil && ok && errors.Is(ctx.Err(), context.Canceled) && w.stopped() {
+		w.interrupted(ctx, message, err)
+		return err
+	}
+	if err != nil {
+		w.countFailed(message.stream)
+		if ok {
+			w.releaseDedup(context.WithoutCancel(w.ctx), message)
+		}
+	} else if ok {
+		w.markProcessed(context.WithoutCancel(w.ctx), message)
+	}
+
+	if !w.opts.ackOnSuccess || w.opts.noAck {
+		return err
+	}
+
+	if err != nil {
+		if ok {
+			w.messageLogger(message).Error("leave message pending after failure", "error", err)
+		}
+		return err
+	}
+
+	if ok && w.acking() {
+		if err := w.ack(context.WithoutCancel(w.ctx), message.stream, message.ID); err != nil {
+			w.messageLogger(message).Error("can't ack message", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// willRetry reports whether golang-queue calls Run again with the task
+// after the failed attempt, it decrements RetryCount once Run returns.
+func willRetry(ctx context.Context, task core.TaskMessage, err error) bool {
+	m, ok := task.(*job.Message)
+	return err != nil && ok && m.RetryCount > 0 && ctx.Err() == nil
+}
+
+// stopped reports whether Shutdown was called
+func (w *Worker) stopped() bool {
+	return atomic.LoadInt32(&w.stopFlag) == 1
+}
+
+// interrupted leaves a message whose processing was cancelled for a
+// redelivery, it's neither counted as failed nor dead-lettered. A message
+// acked on delivery is re-queued so it isn't lost, by Shutdown already
+// when it was still running once the drain timed out.
+func (w *Worker) interrupted(ctx context.Context, message streamMessage, err error) {
+	log := w.messageLogger(message)
+	if w.opts.ackOnSuccess {
+		log.Info("processing interrupted, leave message pending", "error", err)
+		return
+	}
+
+	log.Info("processing interrupted", "error", err)
+	w.requeue(ctx, message, false)
+}
+
+// runTask calls the run func, bounded by the process timeout if any.
+// Run returns once the timeout expires even if the run func ignores
+// the cancellation of its context.
+func (w *Worker) runTask(ctx context.Context, task core.TaskMessage) error {
+	if w.opts.processTimeout <= 0 {
+		return w.call(ctx, task)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.opts.processTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	panicChan := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		done <- w.call(ctx, task)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case p := <-panicChan:
+		panic(p)
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %s", ErrProcessTimeout, w.opts.processTimeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// call calls the run func, a panic is turned into an error
+// when panic recovery is enabled
+func (w *Worker) call(ctx context.Context, task core.TaskMessage) (err error) {
+	if w.opts.panicRecovery {
+		defer func() {
+			if p := recover(); p != nil {
+				w.opts.logger.Errorf("panic while running task: %v\n%s", p, debug.Stack())
+				err = fmt.Errorf("%w: %v", ErrTaskPanic, p)
+			}
+		}()
+	}
+
 	return w.opts.runFunc(ctx, task)
 }
 
+// done marks the task as processed, retries of the same task
+// by the queue don't count it twice
+func (w *Worker) done(task core.TaskMessage) {
+	if _, loaded := w.pending.LoadAndDelete(task); loaded {
+		atomic.AddInt64(&w.inFlight, -1)
+	}
+	w.requeued.Delete(task)
+}
+
+// decode reads the task out of the payload field of the stream entry
+func (w *Worker) decode(task streamMessage) (*job.Message, error) {
+	payload, err := w.validPayload(task)
+	if err != nil {
+		return nil, err
+	}
+
+	var data job.Message
+	if err := w.opts.codec.Unmarshal(payload, &data); err != nil {
+		return nil, fmt.Errorf("%w: message %s: %w", ErrDecodePayload, task.ID, err)
+	}
+
+	return &data, nil
+}
+
+// decodeTask decodes the task returned by Request, with the unmarshal
+// func if any.
+func (w *Worker) decodeTask(task streamMessage) (*job.Message, error) {
+	if w.opts.unmarshalFunc == nil {
+		return w.decode(task)
+	}
+
+	payload, err := w.validPayload(task)
+	if err != nil {
+		return nil, err
+	}
+	data, err := w.opts.unmarshalFunc(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: message %s: %w", ErrDecodePayload, task.ID, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: message %s: nil task", ErrDecodePayload, task.ID)
+	}
+
+	return data, nil
+}
+
+// validPayload returns the payload of the message once accepted by the
+// message validator if any.
+func (w *Worker) validPayload(task streamMessage) ([]byte, error) {
+	payload, err := w.payload(task)
+	if err != nil {
+		return nil, err
+	}
+	if w.opts.messageValidator != nil {
+		if err := w.opts.messageValidator(payload); err != nil {
+			return nil, fmt.Errorf("%w: message %s: %w", ErrInvalidMessage, task.ID, err)
+		}
+	}
+
+	return payload, nil
+}
+
+// payload returns the raw payload of the message, loaded from the large
+// payload handler and decompressed if needed.
+func (w *Worker) payload(task streamMessage) ([]byte, error) {
+	body, ok := w.bodyBytes(task.Values[w.opts.payloadField])
+	if !ok {
+		stored, isRef, err := w.loadPayload(w.ctx, task)
+		if err != nil {
+			return nil, fmt.Errorf("%w: message %s: %w", ErrDecodePayload, task.ID, err)
+		}
+		if !isRef {
+			return nil, fmt.Errorf("%w: field %q of message %s",
+				ErrInvalidPayload, w.opts.payloadField, task.ID)
+		}
+		body = bytesconv.StrToBytes(stored)
+	}
+
+	compression, _ := task.Values[compressionField].(string)
+	payload, err := w.decompress(body, compression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: message %s: %w", ErrDecodePayload, task.ID, err)
+	}
+
+	return payload, nil
+}
+
+// reject skips a message which failed the validation, it's moved to the
+// dead-letter stream when dead-lettering is enabled. Otherwise a message
+// acked on delivery is gone, so its payload is logged.
+func (w *Worker) reject(task streamMessage, cause error) {
+	atomic.AddInt64(&w.inFlight, -1)
+	log := w.messageLogger(task)
+	log.Error("reject invalid message", "error", cause)
+	if w.opts.maxRetries <= 0 {
+		if w.opts.ackOnSuccess && !w.opts.noAck {
+			return
+		}
+		if payload, err := w.payload(task); err == nil {
+			log.Error("drop invalid message", "payload", string(payload))
+		}
+		return
+	}
+
+	if err := w.deadLetter(context.WithoutCancel(w.ctx), task, 0, cause); err != nil {
+		log.Error("can't dead-letter message", "error", err)
+	}
+}
+
 // Request a new task
 func (w *Worker) Request() (core.TaskMessage, error) {
-	clock := 0
 	w.startConsumer()
-loop:
+
+	// a nil channel blocks until a task arrives or the worker stops
+	var timeout <-chan time.Time
+	if w.opts.requestTimeout > 0 {
+		timer := time.NewTimer(w.opts.requestTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
 	for {
 		select {
 		case task, ok := <-w.tasks:
 			if !ok {
 				return nil, queue.ErrQueueHasBeenClosed
 			}
-			var data job.Message
-			_ = json.Unmarshal(bytesconv.StrToBytes(task.Values["body"].(string)), &data)
-			return &data, nil
-		case <-time.After(1 * time.Second):
-			if clock == 5 {
-				break loop
+			data, err := w.decodeTask(task)
+			if errors.Is(err, ErrInvalidMessage) {
+				w.reject(task, err)
+				continue
+			}
+			if err != nil {
+				atomic.AddInt64(&w.inFlight, -1)
+				return nil, err
 			}
-			clock += 1
+			w.pending.Store(data, task)
+			return data, nil
+		case <-timeout:
+			return nil, queue.ErrNoTaskInQueue
 		}
 	}
-
-	return nil, queue.ErrNoTaskInQueue
 }
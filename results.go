@@ -0,0 +1,56 @@
+package redisdb
+
+import (
+	"sync"
+	"time"
+)
+
+// resultsBuffer is the capacity of the results channel
+const resultsBuffer = 100
+
+// ProcessResult describes a task processed by Run
+type ProcessResult struct {
+	MessageID string
+	Stream    string
+	Success   bool
+	Err       error
+	Duration  time.Duration
+}
+
+// resultChannel is the results channel guarded against sends once closed
+type resultChannel struct {
+	mu     sync.RWMutex
+	ch     chan ProcessResult
+	closed bool
+}
+
+// send publishes the result, it's dropped when the buffer is full
+func (r *resultChannel) send(result ProcessResult) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return
+	}
+	select {
+	case r.ch <- result:
+	default:
+	}
+}
+
+func (r *resultChannel) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.closed {
+		r.closed = true
+		close(r.ch)
+	}
+}
+
+// Results returns a channel receiving the outcome of each task processed
+// by Run. It's buffered and the results are dropped when nobody reads
+// them, the channel is closed by Shutdown.
+func (w *Worker) Results() <-chan ProcessResult {
+	return w.results.ch
+}
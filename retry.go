@@ -0,0 +1,71 @@
+package redisdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// isRetriable reports whether a failed command may succeed if retried,
+// e.g. on a network error or a cluster redirection, unlike a command
+// error such as WRONGTYPE.
+func isRetriable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	msg := err.Error()
+	for _, prefix := range []string{"MOVED ", "ASK ", "TRYAGAIN", "CLUSTERDOWN", "LOADING"} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withPublishRetry calls fn until it succeeds, fails with an error which
+// isn't retriable or runs out of publish attempts.
+func (w *Worker) withPublishRetry(ctx context.Context, fn func() error) error {
+	attempts, err := w.withRetry(ctx, w.opts.publishAttempts, w.opts.publishBackoff, fn)
+	if err != nil && attempts > 1 {
+		return fmt.Errorf("publish failed after %d attempts: %w", attempts, err)
+	}
+
+	return err
+}
+
+// withRetry calls fn up to attempts times with a backoff starting at base
+// between them, until it succeeds or fails with an error which isn't
+// retriable. It returns the number of attempts made.
+func (w *Worker) withRetry(ctx context.Context, attempts int, base time.Duration, fn func() error) (int, error) {
+	if attempts <= 1 {
+		return 1, fn()
+	}
+
+	retry := &backoff{base: base, max: max(base, w.opts.retryMax), jitter: w.opts.backoffJitter}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetriable(err) || attempt == attempts {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(retry.next())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
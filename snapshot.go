@@ -0,0 +1,139 @@
+package redisdb
+
+import (
+	"net/url"
+	"time"
+)
+
+// redacted replaces the secrets in OptionsSnapshot
+const redacted = "xxxxx"
+
+// OptionsSnapshot is a copy of the options resolved by NewWorker, e.g. to
+// check which With... calls took effect. The secrets are redacted and it
+// can be encoded as JSON for a debug endpoint.
+type OptionsSnapshot struct {
+	Addr             string   `json:"addr,omitempty"`
+	DB               int      `json:"db"`
+	ConnectionString string   `json:"connection_string,omitempty"`
+	Username         string   `json:"username,omitempty"`
+	Password         string   `json:"password,omitempty"`
+	Cluster          bool     `json:"cluster"`
+	MasterName       string   `json:"master_name,omitempty"`
+	SentinelAddrs    []string `json:"sentinel_addrs,omitempty"`
+	SentinelPassword string   `json:"sentinel_password,omitempty"`
+	TLS              bool     `json:"tls"`
+	// Client reports whether the client was injected with WithClient
+	Client bool `json:"client"`
+
+	StreamName string   `json:"stream_name"`
+	Streams    []string `json:"streams"`
+	Group      string   `json:"group"`
+	Consumer   string   `json:"consumer"`
+	NoGroup    bool     `json:"no_group"`
+	StartID    string   `json:"start_id"`
+
+	BlockTime    time.Duration `json:"block_time"`
+	PollInterval time.Duration `json:"poll_interval"`
+	ReadCount    int           `json:"read_count"`
+	Concurrency  int           `json:"concurrency"`
+	TaskBuffer   int           `json:"task_buffer"`
+	MaxInFlight  int           `json:"max_in_flight"`
+	RateLimit    float64       `json:"rate_limit"`
+
+	AckOnSuccess     bool          `json:"ack_on_success"`
+	NoAck            bool          `json:"no_ack"`
+	AckBatchSize     int           `json:"ack_batch_size"`
+	AckFlushInterval time.Duration `json:"ack_flush_interval"`
+
+	MaxRetries          int           `json:"max_retries"`
+	DeadLetterStream    string        `json:"dead_letter_stream,omitempty"`
+	RecoverPending      bool          `json:"recover_pending"`
+	RecoverPendingCount int64         `json:"recover_pending_count"`
+	AutoClaimInterval   time.Duration `json:"auto_claim_interval"`
+	AutoClaimMinIdle    time.Duration `json:"auto_claim_min_idle"`
+
+	MaxLength int64  `json:"max_length"`
+	MinID     string `json:"min_id,omitempty"`
+	Approx    bool   `json:"approx"`
+
+	ConnectTimeout  time.Duration `json:"connect_timeout"`
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	RequestTimeout  time.Duration `json:"request_timeout"`
+	ProcessTimeout  time.Duration `json:"process_timeout"`
+}
+
+// Options returns a snapshot of the resolved options, changing it doesn't
+// affect the worker.
+func (w *Worker) Options() OptionsSnapshot {
+	o := w.opts
+	return OptionsSnapshot{
+		Addr:             o.addr,
+		DB:               o.db,
+		ConnectionString: redactURL(o.connectionString),
+		Username:         o.username,
+		Password:         redact(o.password),
+		Cluster:          o.cluster,
+		MasterName:       o.masterName,
+		SentinelAddrs:    append([]string(nil), o.sentinelAddrs...),
+		SentinelPassword: redact(o.sentinelPassword),
+		TLS:              o.tls != nil,
+		Client:           o.client != nil,
+
+		StreamName: o.streamName,
+		Streams:    append([]string(nil), o.streams...),
+		Group:      o.group,
+		Consumer:   o.consumer,
+		NoGroup:    o.noGroup,
+		StartID:    o.startID,
+
+		BlockTime:    o.blockTime,
+		PollInterval: o.pollInterval,
+		ReadCount:    o.readCount,
+		Concurrency:  o.concurrency,
+		TaskBuffer:   o.taskBuffer,
+		MaxInFlight:  o.maxInFlight,
+		RateLimit:    o.rateLimit,
+
+		AckOnSuccess:     o.ackOnSuccess,
+		NoAck:            o.noAck,
+		AckBatchSize:     o.ackBatchSize,
+		AckFlushInterval: o.ackFlushInterval,
+
+		MaxRetries:          o.maxRetries,
+		DeadLetterStream:    o.deadLetterStream,
+		RecoverPending:      o.recoverPending,
+		RecoverPendingCount: o.recoverPendingCount,
+		AutoClaimInterval:   o.autoClaimInterval,
+		AutoClaimMinIdle:    o.autoClaimMinIdle,
+
+		MaxLength: o.maxLength,
+		MinID:     o.minID,
+		Approx:    o.approx,
+
+		ConnectTimeout:  o.connectTimeout,
+		ShutdownTimeout: o.shutdownTimeout,
+		RequestTimeout:  o.requestTimeout,
+		ProcessTimeout:  o.processTimeout,
+	}
+}
+
+// redact hides a non-empty secret
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURL hides the password of a connection string, the whole string
+// if it can't be parsed
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,125 @@
+package redisdb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WorkerStats is a snapshot of the worker counters, it can be encoded
+// as JSON for a debug endpoint.
+type WorkerStats struct {
+	Read     int64     `json:"read"`
+	Acked    int64     `json:"acked"`
+	Failed   int64     `json:"failed"`
+	Requeued int64     `json:"requeued"`
+	InFlight int64     `json:"in_flight"`
+	LastRead time.Time `json:"last_read"`
+	// Streams holds the counters of each stream when the worker reads
+	// several streams
+	Streams map[string]StreamStats `json:"streams,omitempty"`
+}
+
+// StreamStats is a snapshot of the counters of a single stream
+type StreamStats struct {
+	Read   int64 `json:"read"`
+	Acked  int64 `json:"acked"`
+	Failed int64 `json:"failed"`
+}
+
+// counters are the worker counters updated atomically
+type counters struct {
+	read     int64
+	acked    int64
+	failed   int64
+	requeued int64
+	// lastRead is the unix nano time of the last successful read
+	lastRead int64
+}
+
+// streamCounters are the counters of a single stream updated atomically
+type streamCounters struct {
+	read   int64
+	acked  int64
+	failed int64
+}
+
+// newStreamCounters returns the counters of each stream, there are none
+// for a single stream since they would match the worker counters
+func newStreamCounters(streams []string) map[string]*streamCounters {
+	if len(streams) < 2 {
+		return nil
+	}
+
+	counters := make(map[string]*streamCounters, len(streams))
+	for _, stream := range streams {
+		counters[stream] = &streamCounters{}
+	}
+	return counters
+}
+
+// countRead counts a message read from the stream
+func (w *Worker) countRead(stream string) {
+	w.opts.metrics.IncRead()
+	atomic.AddInt64(&w.counters.read, 1)
+	if m, ok := w.opts.metrics.(StreamMetrics); ok {
+		m.IncStreamRead(stream)
+	}
+	if c := w.streamCounters[stream]; c != nil {
+		atomic.AddInt64(&c.read, 1)
+	}
+}
+
+// countAcks counts n messages acked on the stream
+func (w *Worker) countAcks(stream string, n int) {
+	m, ok := w.opts.metrics.(StreamMetrics)
+	for i := 0; i < n; i++ {
+		w.opts.metrics.IncAck()
+		if ok {
+			m.IncStreamAck(stream)
+		}
+	}
+	atomic.AddInt64(&w.counters.acked, int64(n))
+	if c := w.streamCounters[stream]; c != nil {
+		atomic.AddInt64(&c.acked, int64(n))
+	}
+}
+
+// countFailed counts a message of the stream whose processing failed,
+// the stream is empty if the message isn't known
+func (w *Worker) countFailed(stream string) {
+	w.opts.metrics.IncFailed()
+	atomic.AddInt64(&w.counters.failed, 1)
+	if m, ok := w.opts.metrics.(StreamMetrics); ok && stream != "" {
+		m.IncStreamFailed(stream)
+	}
+	if c := w.streamCounters[stream]; c != nil {
+		atomic.AddInt64(&c.failed, 1)
+	}
+}
+
+// Stats returns a snapshot of the worker counters, LastRead is zero
+// until a read returns messages.
+func (w *Worker) Stats() WorkerStats {
+	stats := WorkerStats{
+		Read:     atomic.LoadInt64(&w.counters.read),
+		Acked:    atomic.LoadInt64(&w.counters.acked),
+		Failed:   atomic.LoadInt64(&w.counters.failed),
+		Requeued: atomic.LoadInt64(&w.counters.requeued),
+		InFlight: atomic.LoadInt64(&w.inFlight),
+	}
+	if n := atomic.LoadInt64(&w.counters.lastRead); n > 0 {
+		stats.LastRead = time.Unix(0, n)
+	}
+	if len(w.streamCounters) > 0 {
+		stats.Streams = make(map[string]StreamStats, len(w.streamCounters))
+		for stream, c := range w.streamCounters {
+			stats.Streams[stream] = StreamStats{
+				Read:   atomic.LoadInt64(&c.read),
+				Acked:  atomic.LoadInt64(&c.acked),
+				Failed: atomic.LoadInt64(&c.failed),
+			}
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,27 @@
+package redisdb
+
+import (
+	"context"
+)
+
+// tailIDs sets the ID after which each stream is read without a consumer
+// group. The start ID "$" is resolved to the last entry once so the
+// entries added between two reads aren't skipped.
+func (w *Worker) tailIDs(ctx context.Context, ids []string) {
+	for i, stream := range w.opts.streams {
+		ids[i] = w.opts.startID
+		if w.opts.startID != "$" {
+			continue
+		}
+
+		last, err := w.rdb.XRevRangeN(ctx, stream, "+", "-", 1).Result()
+		if err != nil {
+			w.opts.logger.Errorf("can't read the last entry of %s: %v", stream, err)
+			continue
+		}
+		ids[i] = "0-0"
+		if len(last) > 0 {
+			ids[i] = last[0].ID
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package redisdb
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName is the instrumentation name of the worker spans
+	tracerName = "github.com/golang-queue/redisdb-stream"
+	// processSpanName is the name of the span around the processing of a message
+	processSpanName = "redisdb.process"
+)
+
+// traceFields are the W3C trace context fields a producer can set on a
+// message to link its processing to the producing trace
+var traceFields = []string{"traceparent", "tracestate"}
+
+// startSpan starts the processing span of the message, linked to the
+// trace context carried by the message fields if any.
+func (w *Worker) startSpan(ctx context.Context, message streamMessage) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("redisdb.stream", message.stream),
+			attribute.String("redisdb.group", w.opts.group),
+			attribute.String("redisdb.consumer", message.consumer),
+			attribute.String("redisdb.message_id", message.ID),
+		),
+	}
+	if remote := remoteSpanContext(message); remote.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: remote}))
+	}
+
+	return w.opts.tracerProvider.Tracer(tracerName).Start(ctx, processSpanName, opts...)
+}
+
+// remoteSpanContext extracts the trace context carried by the message
+func remoteSpanContext(message streamMessage) trace.SpanContext {
+	carrier := propagation.MapCarrier{}
+	for _, field := range traceFields {
+		if v, ok := message.Values[field].(string); ok {
+			carrier[field] = v
+		}
+	}
+
+	ctx := propagation.TraceContext{}.Extract(context.Background(), carrier)
+	return trace.SpanContextFromContext(ctx)
+}
+
+// endSpan records the outcome of the processing and ends the span
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
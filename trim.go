@@ -0,0 +1,69 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TrimStrategy decides how the periodic trimmer shrinks the streams,
+// see TrimByMaxLen and TrimByAge
+type TrimStrategy struct {
+	maxLen int64
+	maxAge time.Duration
+}
+
+// TrimByMaxLen keeps at most the n latest entries of each stream
+func TrimByMaxLen(n int64) TrimStrategy {
+	return TrimStrategy{maxLen: n}
+}
+
+// TrimByAge removes the entries older than d, based on the
+// timestamp part of their ID
+func TrimByAge(d time.Duration) TrimStrategy {
+	return TrimStrategy{maxAge: d}
+}
+
+// validate rejects a strategy which would empty the streams
+func (s TrimStrategy) validate() error {
+	if s.maxLen < 0 || s.maxAge < 0 || (s.maxLen == 0 && s.maxAge == 0) {
+		return fmt.Errorf("%w: max len %d, max age %s", ErrInvalidTrimStrategy, s.maxLen, s.maxAge)
+	}
+	return nil
+}
+
+// periodicTrim trims the streams on every tick, independently of XADD
+func (w *Worker) periodicTrim() {
+	ticker := time.NewTicker(w.opts.trimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, stream := range w.opts.streams {
+			n, err := w.trim(w.ctx, stream)
+			if err != nil {
+				w.opts.logger.Errorf("can't trim stream %s: %v", stream, err)
+				continue
+			}
+			// logged even when nothing was trimmed, a strategy which
+			// never trims shows up too
+			w.opts.logger.Infof("trimmed %d entries of stream %s", n, stream)
+		}
+	}
+}
+
+func (w *Worker) trim(ctx context.Context, stream string) (int64, error) {
+	if w.opts.trimStrategy.maxAge > 0 {
+		minID := idFromTime(time.Now().Add(-w.opts.trimStrategy.maxAge))
+		return w.rdb.XTrimMinID(ctx, stream, minID).Result()
+	}
+
+	return w.rdb.XTrimMaxLen(ctx, stream, w.opts.trimStrategy.maxLen).Result()
+}